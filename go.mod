@@ -9,6 +9,7 @@ require (
 	github.com/kerimovok/go-pkg-database v1.1.0
 	github.com/kerimovok/go-pkg-utils v1.1.0
 	github.com/rabbitmq/amqp091-go v1.10.0
+	github.com/santhosh-tekuri/jsonschema/v6 v6.0.2
 	go.mongodb.org/mongo-driver v1.17.4
 )
 
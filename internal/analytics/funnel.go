@@ -0,0 +1,267 @@
+// Package analytics implements behavioural analyses that follow actors across events,
+// such as conversion funnels, on top of the events stored by the service
+package analytics
+
+import (
+	"context"
+	"events-api/internal/constants"
+	"events-api/internal/database"
+	"events-api/internal/utils"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EventMatch selects the events with the given name matching the filters
+type EventMatch struct {
+	Name    string
+	Filters bson.M // compiled filter expression, nil to match every event with the name
+}
+
+// filter returns the query matching the events of an actor
+func (m EventMatch) filter(actor string) bson.M {
+	return utils.CombineFilters(
+		bson.M{"name": m.Name},
+		m.Filters,
+		bson.M{actor: bson.M{"$ne": nil}},
+		utils.NotDeletedFilter(),
+	)
+}
+
+// Funnel describes a conversion funnel
+// An actor converts to a step when they perform it after the previous ones, no later than Window
+// after the first step. Each actor is counted once, with the furthest step of their best attempt.
+type Funnel struct {
+	Steps     []EventMatch
+	Actor     string        // field identifying who performed the events, e.g. properties.user_id
+	Window    time.Duration // time allowed from the first to the last step
+	TimeField string        // created_at or occurred_at
+	From      time.Time     // inclusive start of the first step, zero for unbounded
+	To        time.Time     // exclusive end of the first step, later steps may follow up to Window after it; zero for unbounded
+}
+
+// FunnelStepResult reports how many actors reached a step of a funnel
+type FunnelStepResult struct {
+	Name                      string   `json:"name"`
+	Count                     int64    `json:"count"`
+	ConversionRate            float64  `json:"conversion_rate"`                        // share of the actors of the first step
+	StepConversionRate        float64  `json:"step_conversion_rate"`                   // share of the actors of the previous step
+	MedianSecondsFromPrevious *float64 `json:"median_seconds_from_previous,omitempty"` // unset on the first step and steps nobody reached
+}
+
+// ParseWindow parses a conversion window such as 30m, 24h or 7d
+func ParseWindow(window string) (time.Duration, error) {
+	return parseDuration("window", window, constants.MaxFunnelWindow)
+}
+
+// parseDuration parses a positive duration of at most max, accepting days (7d) on top of
+// time.ParseDuration's units. name is the parameter reported in errors.
+func parseDuration(name, value string, max time.Duration) (time.Duration, error) {
+	var (
+		duration time.Duration
+		err      error
+	)
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		duration = time.Duration(n) * 24 * time.Hour
+	} else {
+		duration, err = time.ParseDuration(value)
+	}
+
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration such as 30m, 24h or 7d", name)
+	}
+	if duration > max {
+		if max%(24*time.Hour) == 0 {
+			return 0, fmt.Errorf("%s must not be longer than %dd", name, int(max.Hours()/24))
+		}
+		return 0, fmt.Errorf("%s must not be longer than %s", name, max)
+	}
+	return duration, nil
+}
+
+// ComputeFunnel counts the actors reaching every step of a funnel
+//
+// The events of every step are collected with $unionWith, sorted by actor and time and grouped
+// per actor, keeping the first constants.MaxFunnelEventsPerActor of each. A $reduce then walks
+// each actor's events as a state machine, restarting the attempt on a first step event once the
+// window of the current attempt is over, and keeps the furthest attempt. Only one document per
+// actor leaves the server, counts and medians are computed here.
+func ComputeFunnel(ctx context.Context, funnel Funnel) ([]FunnelStepResult, error) {
+	if len(funnel.Steps) == 0 {
+		return nil, fmt.Errorf("funnel requires at least one step")
+	}
+
+	pipeline := stepEventsPipeline(funnel, 0)
+	for i := 1; i < len(funnel.Steps); i++ {
+		pipeline = append(pipeline, bson.M{"$unionWith": bson.M{
+			"coll":     constants.EventsCollection,
+			"pipeline": stepEventsPipeline(funnel, i),
+		}})
+	}
+	pipeline = append(pipeline,
+		bson.M{"$setWindowFields": bson.M{
+			"partitionBy": "$a",
+			"sortBy":      bson.D{{Key: "t", Value: 1}, {Key: "s", Value: 1}},
+			"output":      bson.M{"n": bson.M{"$documentNumber": bson.M{}}},
+		}},
+		bson.M{"$match": bson.M{"n": bson.M{"$lte": constants.MaxFunnelEventsPerActor}}},
+		bson.M{"$sort": bson.D{{Key: "a", Value: 1}, {Key: "t", Value: 1}, {Key: "s", Value: 1}}},
+		bson.M{"$group": bson.M{"_id": "$a", "events": bson.M{"$push": bson.M{"s": "$s", "t": "$t"}}}},
+		bson.M{"$project": bson.M{"_id": 0, "progress": funnelProgressExpr(funnel.Window)}},
+	)
+
+	collection := database.DBClient.Database().Collection(constants.EventsCollection)
+	cursor, err := collection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true).SetMaxTime(constants.AnalyticsTimeout))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	counts := make([]int64, len(funnel.Steps))
+	durations := make([][]float64, len(funnel.Steps))
+	for cursor.Next(ctx) {
+		var actor struct {
+			Progress struct {
+				Best      int                  `bson:"best"`
+				BestTimes []primitive.DateTime `bson:"bestTimes"`
+			} `bson:"progress"`
+		}
+		if err := cursor.Decode(&actor); err != nil {
+			return nil, err
+		}
+
+		times := actor.Progress.BestTimes
+		for step := 0; step < actor.Progress.Best && step < len(counts); step++ {
+			counts[step]++
+			if step > 0 && step < len(times) {
+				durations[step] = append(durations[step], float64(times[step]-times[step-1])/1000)
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	results := make([]FunnelStepResult, len(funnel.Steps))
+	for i, step := range funnel.Steps {
+		results[i] = FunnelStepResult{
+			Name:  step.Name,
+			Count: counts[i],
+		}
+		if counts[0] > 0 {
+			results[i].ConversionRate = float64(counts[i]) / float64(counts[0])
+		}
+		if i == 0 {
+			if counts[0] > 0 {
+				results[i].StepConversionRate = 1
+			}
+			continue
+		}
+		if counts[i-1] > 0 {
+			results[i].StepConversionRate = float64(counts[i]) / float64(counts[i-1])
+		}
+		if median, ok := utils.Percentile(durations[i], 0.5); ok {
+			results[i].MedianSecondsFromPrevious = &median
+		}
+	}
+
+	return results, nil
+}
+
+// stepEventsPipeline returns the stages selecting the events of a step as {a: actor, t: time, s: step}
+func stepEventsPipeline(funnel Funnel, step int) []bson.M {
+	// Later steps may happen up to a window after the first step started
+	to := funnel.To
+	from := funnel.From
+	if step > 0 && !to.IsZero() {
+		to = to.Add(funnel.Window)
+	}
+
+	match := utils.CombineFilters(
+		funnel.Steps[step].filter(funnel.Actor),
+		utils.TimeRangeFilter(funnel.TimeField, from, to),
+	)
+
+	return []bson.M{
+		{"$match": match},
+		{"$project": bson.M{
+			"_id": 0,
+			"a":   "$" + funnel.Actor,
+			"t":   utils.TimeFieldExpr(funnel.TimeField),
+			"s":   bson.M{"$literal": step},
+		}},
+	}
+}
+
+// funnelProgressExpr returns the $reduce walking the time-ordered events of an actor
+// The state holds the current attempt (step reached, start time and step times) and the best one.
+func funnelProgressExpr(window time.Duration) bson.M {
+	withinWindow := bson.M{"$lte": bson.A{
+		bson.M{"$subtract": bson.A{"$$this.t", "$$value.start"}},
+		window.Milliseconds(),
+	}}
+
+	// A first step event starts a new attempt unless the current one is still within its window
+	restart := bson.M{"$and": bson.A{
+		bson.M{"$eq": bson.A{"$$this.s", 0}},
+		bson.M{"$or": bson.A{
+			bson.M{"$eq": bson.A{"$$value.step", 0}},
+			bson.M{"$not": bson.A{withinWindow}},
+		}},
+	}}
+
+	// The next step of the current attempt moves it forward while within the window
+	advance := bson.M{"$and": bson.A{
+		bson.M{"$gt": bson.A{"$$value.step", 0}},
+		bson.M{"$eq": bson.A{"$$this.s", "$$value.step"}},
+		withinWindow,
+	}}
+
+	attempt := bson.M{"$switch": bson.M{
+		"branches": bson.A{
+			bson.M{"case": restart, "then": bson.M{
+				"step":  1,
+				"start": "$$this.t",
+				"times": bson.A{"$$this.t"},
+			}},
+			bson.M{"case": advance, "then": bson.M{
+				"step":  bson.M{"$add": bson.A{"$$value.step", 1}},
+				"start": "$$value.start",
+				"times": bson.M{"$concatArrays": bson.A{"$$value.times", bson.A{"$$this.t"}}},
+			}},
+		},
+		"default": bson.M{
+			"step":  "$$value.step",
+			"start": "$$value.start",
+			"times": "$$value.times",
+		},
+	}}
+
+	return bson.M{"$reduce": bson.M{
+		"input": "$events",
+		"initialValue": bson.M{
+			"step":      0,
+			"start":     nil,
+			"times":     bson.A{},
+			"best":      0,
+			"bestTimes": bson.A{},
+		},
+		"in": bson.M{"$let": bson.M{
+			"vars": bson.M{"attempt": attempt},
+			"in": bson.M{
+				"step":      "$$attempt.step",
+				"start":     "$$attempt.start",
+				"times":     "$$attempt.times",
+				"best":      bson.M{"$max": bson.A{"$$value.best", "$$attempt.step"}},
+				"bestTimes": bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{"$$attempt.step", "$$value.best"}}, "$$attempt.times", "$$value.bestTimes"}},
+			},
+		}},
+	}}
+}
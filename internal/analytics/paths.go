@@ -0,0 +1,158 @@
+package analytics
+
+import (
+	"context"
+	"events-api/internal/constants"
+	"events-api/internal/database"
+	"events-api/internal/utils"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Paths describes a path analysis around an anchor event
+// For every anchor event, the path is the names of the Depth events the same actor performed
+// right before or after it, in chronological order. Paths are shorter when the actor has fewer
+// events in that direction.
+type Paths struct {
+	Anchor    EventMatch
+	Direction string    // constants.PathDirectionBefore or constants.PathDirectionAfter
+	Depth     int       // number of events in a path
+	Actor     string    // field identifying who performed the events, e.g. properties.user_id
+	TimeField string    // created_at or occurred_at
+	From      time.Time // inclusive start of the events, zero for unbounded
+	To        time.Time // exclusive end of the events, zero for unbounded
+	Limit     int       // number of paths returned
+}
+
+// PathResult is an event sequence found around the anchor
+type PathResult struct {
+	Events     []string `json:"events"`
+	Count      int64    `json:"count"`      // anchor events followed (or preceded) by the sequence
+	Actors     int64    `json:"actors"`     // distinct actors with the sequence
+	Percentage float64  `json:"percentage"` // share of all anchor events
+}
+
+// ComputePaths returns the most frequent paths around the anchor event, and the number of anchors
+//
+// $setWindowFields pushes the names of the neighbouring events of every event of an actor into a
+// documents window, then only the anchor events are kept and grouped by path. The window has to
+// see every event, so events are only reduced to the fields the window reads and whether they
+// are anchors beforehand. Anchors with filters are told apart by matching the events that are
+// anchors and the ones that aren't separately, as filters are queries rather than expressions.
+func ComputePaths(ctx context.Context, paths Paths) ([]PathResult, int64, error) {
+	if paths.Depth < 1 {
+		return nil, 0, fmt.Errorf("paths require a depth of at least one event")
+	}
+
+	window := bson.A{1, paths.Depth}
+	if paths.Direction == constants.PathDirectionBefore {
+		window = bson.A{-paths.Depth, -1}
+	}
+
+	match := utils.CombineFilters(
+		bson.M{paths.Actor: bson.M{"$ne": nil}},
+		utils.NotDeletedFilter(),
+		utils.TimeRangeFilter(paths.TimeField, paths.From, paths.To),
+	)
+	project := func(anchor interface{}) bson.M {
+		return bson.M{"$project": bson.M{
+			"name":    1,
+			"_a":      "$" + paths.Actor,
+			"_t":      utils.TimeFieldExpr(paths.TimeField),
+			"_anchor": anchor,
+		}}
+	}
+
+	var pipeline []bson.M
+	if paths.Anchor.Filters == nil {
+		pipeline = []bson.M{
+			{"$match": match},
+			project(bson.M{"$eq": bson.A{"$name", paths.Anchor.Name}}),
+		}
+	} else {
+		anchor := paths.Anchor.filter(paths.Actor)
+		pipeline = []bson.M{
+			{"$match": utils.CombineFilters(match, bson.M{"$nor": bson.A{anchor}})},
+			project(bson.M{"$literal": false}),
+			{"$unionWith": bson.M{
+				"coll": constants.EventsCollection,
+				"pipeline": []bson.M{
+					{"$match": utils.CombineFilters(match, anchor)},
+					project(bson.M{"$literal": true}),
+				},
+			}},
+		}
+	}
+
+	pipeline = append(pipeline,
+		bson.M{"$setWindowFields": bson.M{
+			"partitionBy": "$_a",
+			"sortBy":      bson.D{{Key: "_t", Value: 1}, {Key: "_id", Value: 1}},
+			"output": bson.M{"_path": bson.M{
+				"$push":  "$name",
+				"window": bson.M{"documents": window},
+			}},
+		}},
+		bson.M{"$match": bson.M{"_anchor": true}},
+		bson.M{"$facet": bson.M{
+			"paths": []bson.M{
+				// Count every actor once per path first, so distinct actors need no set per path
+				{"$group": bson.M{"_id": bson.M{"p": "$_path", "a": "$_a"}, "count": bson.M{"$sum": 1}}},
+				{"$group": bson.M{"_id": "$_id.p", "count": bson.M{"$sum": "$count"}, "actors": bson.M{"$sum": 1}}},
+				{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "actors", Value: -1}, {Key: "_id", Value: 1}}},
+				{"$limit": paths.Limit},
+			},
+			"anchors": []bson.M{{"$count": "count"}},
+		}},
+	)
+
+	collection := database.DBClient.Database().Collection(constants.EventsCollection)
+	cursor, err := collection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true).SetMaxTime(constants.AnalyticsTimeout))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var facets []struct {
+		Paths []struct {
+			Events []string `bson:"_id"`
+			Count  int64    `bson:"count"`
+			Actors int64    `bson:"actors"`
+		} `bson:"paths"`
+		Anchors []struct {
+			Count int64 `bson:"count"`
+		} `bson:"anchors"`
+	}
+	if err = cursor.All(ctx, &facets); err != nil {
+		return nil, 0, err
+	}
+
+	results := []PathResult{}
+	var anchors int64
+	if len(facets) == 0 {
+		return results, anchors, nil
+	}
+	if len(facets[0].Anchors) > 0 {
+		anchors = facets[0].Anchors[0].Count
+	}
+
+	for _, path := range facets[0].Paths {
+		result := PathResult{
+			Events: path.Events,
+			Count:  path.Count,
+			Actors: path.Actors,
+		}
+		if result.Events == nil {
+			result.Events = []string{} // anchors without any other event around them
+		}
+		if anchors > 0 {
+			result.Percentage = float64(path.Count) / float64(anchors) * 100
+		}
+		results = append(results, result)
+	}
+
+	return results, anchors, nil
+}
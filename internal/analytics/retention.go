@@ -0,0 +1,182 @@
+package analytics
+
+import (
+	"context"
+	"events-api/internal/constants"
+	"events-api/internal/database"
+	"events-api/internal/utils"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Retention describes a cohort retention analysis
+// Actors join the cohort of the bucket of their first start event between From and To, and are
+// retained in period N when they perform a return event in the Nth bucket after their cohort's.
+type Retention struct {
+	Start     EventMatch
+	Return    EventMatch
+	Actor     string              // field identifying who performed the events, e.g. properties.user_id
+	Bucketing utils.TimeBucketing // cohort interval, time field, location and range of the start events
+	Periods   int                 // number of periods after the cohort's own one
+}
+
+// Cohort is a row of the retention matrix
+// Retained[0] is the size of the cohort, Retained[N] the actors active in the Nth period after it.
+// Periods that have not started yet are left out, which gives the matrix its triangular shape.
+type Cohort struct {
+	Start       string    `json:"cohort"`
+	Actors      int64     `json:"actors"`
+	Retained    []int64   `json:"retained"`
+	Percentages []float64 `json:"percentages"`
+}
+
+// ComputeRetention builds the retention matrix, one row per cohort in chronological order
+//
+// Start and return events are collected with $unionWith and grouped per actor into the start of
+// their cohort and the distinct buckets they returned in. $dateDiff turns those into period numbers,
+// which are counted per cohort and period.
+func ComputeRetention(ctx context.Context, retention Retention) ([]Cohort, error) {
+	if retention.Periods < 1 {
+		return nil, fmt.Errorf("retention requires at least one period")
+	}
+
+	bucketing := retention.Bucketing
+	timeExpr := utils.TimeFieldExpr(bucketing.TimeField)
+
+	startMatch := utils.CombineFilters(
+		retention.Start.filter(retention.Actor),
+		utils.TimeRangeFilter(bucketing.TimeField, bucketing.From, bucketing.To),
+	)
+	// Returns can only count from the first cohort on, and keep coming after the last one started
+	returnMatch := utils.CombineFilters(
+		retention.Return.filter(retention.Actor),
+		utils.TimeRangeFilter(bucketing.TimeField, bucketing.From, time.Time{}),
+	)
+
+	periodExpr := bson.M{"$dateDiff": dateDiffArgs(bucketing, "$cohort", "$$bucket")}
+
+	pipeline := []bson.M{
+		{"$match": startMatch},
+		{"$project": bson.M{"_id": 0, "a": "$" + retention.Actor, "t": timeExpr, "k": "start"}},
+		{"$unionWith": bson.M{
+			"coll": constants.EventsCollection,
+			"pipeline": []bson.M{
+				{"$match": returnMatch},
+				{"$project": bson.M{"_id": 0, "a": "$" + retention.Actor, "t": timeExpr, "k": "return"}},
+			},
+		}},
+		{"$group": bson.M{
+			"_id":   "$a",
+			"first": bson.M{"$min": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$k", "start"}}, "$t", nil}}},
+			"returns": bson.M{"$addToSet": bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{"$k", "return"}},
+				bucketing.TruncateExpr("$t"),
+				"$$REMOVE",
+			}}},
+		}},
+		// Actors who only returned never joined a cohort
+		{"$match": bson.M{"first": bson.M{"$ne": nil}}},
+		{"$project": bson.M{"cohort": bucketing.TruncateExpr("$first"), "returns": 1}},
+		{"$project": bson.M{
+			"cohort": 1,
+			"periods": bson.M{"$concatArrays": bson.A{
+				bson.A{0},
+				bson.M{"$filter": bson.M{
+					"input": bson.M{"$map": bson.M{"input": "$returns", "as": "bucket", "in": periodExpr}},
+					"as":    "period",
+					"cond": bson.M{"$and": bson.A{
+						bson.M{"$gte": bson.A{"$$period", 1}},
+						bson.M{"$lte": bson.A{"$$period", retention.Periods}},
+					}},
+				}},
+			}},
+		}},
+		{"$unwind": "$periods"},
+		{"$group": bson.M{
+			"_id":    bson.M{"cohort": "$cohort", "period": "$periods"},
+			"actors": bson.M{"$sum": 1},
+		}},
+	}
+
+	collection := database.DBClient.Database().Collection(constants.EventsCollection)
+	cursor, err := collection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true).SetMaxTime(constants.AnalyticsTimeout))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var cells []struct {
+		Id struct {
+			Cohort primitive.DateTime `bson:"cohort"`
+			Period int                `bson:"period"`
+		} `bson:"_id"`
+		Actors int64 `bson:"actors"`
+	}
+	if err = cursor.All(ctx, &cells); err != nil {
+		return nil, err
+	}
+
+	rows := make(map[primitive.DateTime][]int64)
+	for _, cell := range cells {
+		row, ok := rows[cell.Id.Cohort]
+		if !ok {
+			row = make([]int64, retention.Periods+1)
+			rows[cell.Id.Cohort] = row
+		}
+		if cell.Id.Period >= 0 && cell.Id.Period < len(row) {
+			row[cell.Id.Period] = cell.Actors
+		}
+	}
+
+	starts := make([]primitive.DateTime, 0, len(rows))
+	for start := range rows {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	now := time.Now()
+	cohorts := make([]Cohort, len(starts))
+	for i, start := range starts {
+		row := rows[start]
+
+		// Only keep the periods that have started
+		periods := 1
+		for period := bucketing.Next(start.Time()); periods < len(row) && period.Before(now); period = bucketing.Next(period) {
+			periods++
+		}
+
+		cohort := Cohort{
+			Start:       bucketing.Format(start.Time()),
+			Actors:      row[0],
+			Retained:    row[:periods],
+			Percentages: make([]float64, periods),
+		}
+		for period, actors := range cohort.Retained {
+			if cohort.Actors > 0 {
+				cohort.Percentages[period] = float64(actors) / float64(cohort.Actors) * 100
+			}
+		}
+		cohorts[i] = cohort
+	}
+
+	return cohorts, nil
+}
+
+// dateDiffArgs returns the $dateDiff arguments counting the buckets between two bucket starts
+func dateDiffArgs(bucketing utils.TimeBucketing, start, end interface{}) bson.M {
+	args := bson.M{
+		"startDate": start,
+		"endDate":   end,
+		"unit":      bucketing.Interval.Unit,
+		"timezone":  bucketing.Location.String(),
+	}
+	if bucketing.Interval.Unit == "week" {
+		args["startOfWeek"] = bucketing.Interval.StartOfWeek
+	}
+	return args
+}
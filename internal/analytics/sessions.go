@@ -0,0 +1,238 @@
+package analytics
+
+import (
+	"context"
+	"events-api/internal/constants"
+	"events-api/internal/database"
+	"events-api/internal/utils"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Sessionization describes how events are grouped into sessions
+// The events of an actor form a session until they stop for longer than Timeout, the next event
+// starts a new one. Sessions are computed on read from the matching events, so a session crossing
+// From or To only counts its events within the range.
+type Sessionization struct {
+	Actor     string        // field identifying who performed the events, e.g. properties.user_id
+	Timeout   time.Duration // inactivity ending a session
+	Filters   bson.M        // events taking part in sessions, nil for every event
+	TimeField string        // created_at or occurred_at
+	From      time.Time     // inclusive start of the events, zero for unbounded
+	To        time.Time     // exclusive end of the events, zero for unbounded
+}
+
+// Session is a run of events of an actor without a gap longer than the timeout
+type Session struct {
+	Actor           interface{} `json:"actor"`
+	Start           time.Time   `json:"start"`
+	End             time.Time   `json:"end"`
+	DurationSeconds float64     `json:"duration_seconds"` // zero for single event sessions
+	Events          int64       `json:"events"`
+}
+
+// SessionStats summarizes the sessions starting in a period
+type SessionStats struct {
+	Sessions            int64    `json:"sessions"`
+	Events              int64    `json:"events"`
+	AvgDurationSeconds  *float64 `json:"avg_duration_seconds"`   // unset without sessions
+	AvgEventsPerSession *float64 `json:"avg_events_per_session"` // unset without sessions
+}
+
+// SessionBucket holds the stats of the sessions starting in a time bucket
+type SessionBucket struct {
+	Start string `json:"_id"` // start of the bucket, as in time series
+	SessionStats
+}
+
+// ParseSessionTimeout parses an inactivity timeout such as 30m or 2h
+func ParseSessionTimeout(timeout string) (time.Duration, error) {
+	return parseDuration("timeout", timeout, constants.MaxSessionTimeout)
+}
+
+// sessionsPipeline returns the stages grouping the matching events into one document per session
+// holding the actor and session number as _id, with start, end and events
+//
+// A first $setWindowFields pass compares every event with the previous one of the same actor and
+// marks the events starting a session, a second one numbers the sessions with a running sum of the
+// marks. Both stages require MongoDB 5.0.
+func sessionsPipeline(sessionization Sessionization) []bson.M {
+	match := utils.CombineFilters(
+		sessionization.Filters,
+		bson.M{sessionization.Actor: bson.M{"$ne": nil}},
+		utils.NotDeletedFilter(),
+		utils.TimeRangeFilter(sessionization.TimeField, sessionization.From, sessionization.To),
+	)
+
+	return []bson.M{
+		{"$match": match},
+		{"$project": bson.M{"_id": 0, "a": "$" + sessionization.Actor, "t": utils.TimeFieldExpr(sessionization.TimeField)}},
+		{"$setWindowFields": bson.M{
+			"partitionBy": "$a",
+			"sortBy":      bson.M{"t": 1},
+			"output":      bson.M{"previous": bson.M{"$shift": bson.M{"output": "$t", "by": -1}}},
+		}},
+		{"$set": bson.M{"new": bson.M{"$cond": bson.A{
+			bson.M{"$or": bson.A{
+				bson.M{"$eq": bson.A{"$previous", nil}},
+				bson.M{"$gt": bson.A{bson.M{"$subtract": bson.A{"$t", "$previous"}}, sessionization.Timeout.Milliseconds()}},
+			}},
+			1,
+			0,
+		}}}},
+		{"$setWindowFields": bson.M{
+			"partitionBy": "$a",
+			"sortBy":      bson.M{"t": 1},
+			"output": bson.M{"session": bson.M{
+				"$sum":   "$new",
+				"window": bson.M{"documents": bson.A{"unbounded", "current"}},
+			}},
+		}},
+		{"$group": bson.M{
+			"_id":    bson.M{"a": "$a", "s": "$session"},
+			"start":  bson.M{"$min": "$t"},
+			"end":    bson.M{"$max": "$t"},
+			"events": bson.M{"$sum": 1},
+		}},
+	}
+}
+
+// ListSessions returns a page of sessions, the most recent first, and whether more follow
+func ListSessions(ctx context.Context, sessionization Sessionization, page, limit int) ([]Session, bool, error) {
+	pipeline := append(sessionsPipeline(sessionization),
+		bson.M{"$sort": bson.D{{Key: "start", Value: -1}, {Key: "_id.a", Value: 1}}},
+		bson.M{"$skip": (page - 1) * limit},
+		// Fetch one more session than requested to tell whether another page follows
+		bson.M{"$limit": limit + 1},
+	)
+
+	collection := database.DBClient.Database().Collection(constants.EventsCollection)
+	cursor, err := collection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true).SetMaxTime(constants.AnalyticsTimeout))
+	if err != nil {
+		return nil, false, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		Id struct {
+			Actor interface{} `bson:"a"`
+		} `bson:"_id"`
+		Start  primitive.DateTime `bson:"start"`
+		End    primitive.DateTime `bson:"end"`
+		Events int64              `bson:"events"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(results) > limit
+	if hasMore {
+		results = results[:limit]
+	}
+
+	sessions := make([]Session, len(results))
+	for i, result := range results {
+		sessions[i] = Session{
+			Actor:           result.Id.Actor,
+			Start:           result.Start.Time().UTC(),
+			End:             result.End.Time().UTC(),
+			DurationSeconds: float64(result.End-result.Start) / 1000,
+			Events:          result.Events,
+		}
+	}
+
+	return sessions, hasMore, nil
+}
+
+// ComputeSessionStats summarizes the sessions overall and per interval, by the bucket they start in
+// The buckets form a contiguous series when both From and To are set, and are otherwise only those
+// sessions start in, like time series, see utils.TimeBucketing.
+func ComputeSessionStats(ctx context.Context, sessionization Sessionization, interval utils.Interval, location *time.Location) (SessionStats, []SessionBucket, error) {
+	bucketing := utils.TimeBucketing{
+		Interval:  interval,
+		TimeField: sessionization.TimeField,
+		Location:  location,
+		From:      sessionization.From,
+		To:        sessionization.To,
+	}
+
+	// The buckets of a bounded series are known before reading the sessions
+	var starts []time.Time
+	if bucketing.Bounded() {
+		for start := bucketing.Truncate(bucketing.From); start.Before(bucketing.To); start = bucketing.Next(start) {
+			if len(starts) >= constants.MaxTimeSeriesBuckets {
+				return SessionStats{}, nil, utils.ErrTooManyBuckets
+			}
+			starts = append(starts, start)
+		}
+	}
+
+	pipeline := append(sessionsPipeline(sessionization),
+		bson.M{"$group": bson.M{
+			"_id":      bucketing.TruncateExpr("$start"),
+			"sessions": bson.M{"$sum": 1},
+			"events":   bson.M{"$sum": "$events"},
+			"duration": bson.M{"$sum": bson.M{"$subtract": bson.A{"$end", "$start"}}},
+		}},
+		bson.M{"$sort": bson.M{"_id": 1}},
+	)
+
+	collection := database.DBClient.Database().Collection(constants.EventsCollection)
+	cursor, err := collection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true).SetMaxTime(constants.AnalyticsTimeout))
+	if err != nil {
+		return SessionStats{}, nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		Start    primitive.DateTime `bson:"_id"`
+		Sessions int64              `bson:"sessions"`
+		Events   int64              `bson:"events"`
+		Duration int64              `bson:"duration"` // milliseconds
+	}
+	if err = cursor.All(ctx, &groups); err != nil {
+		return SessionStats{}, nil, err
+	}
+
+	var (
+		total         SessionStats
+		totalDuration int64
+	)
+	byStart := make(map[int64]SessionStats, len(groups))
+	for _, group := range groups {
+		byStart[int64(group.Start)] = sessionStats(group.Sessions, group.Events, group.Duration)
+		total.Sessions += group.Sessions
+		total.Events += group.Events
+		totalDuration += group.Duration
+
+		if !bucketing.Bounded() {
+			starts = append(starts, group.Start.Time())
+		}
+	}
+	total = sessionStats(total.Sessions, total.Events, totalDuration)
+
+	buckets := make([]SessionBucket, len(starts))
+	for i, start := range starts {
+		buckets[i] = SessionBucket{
+			Start:        bucketing.Format(start),
+			SessionStats: byStart[start.UnixMilli()],
+		}
+	}
+
+	return total, buckets, nil
+}
+
+// sessionStats returns the stats of sessions from their totals, durations in milliseconds
+func sessionStats(sessions, events, duration int64) SessionStats {
+	stats := SessionStats{Sessions: sessions, Events: events}
+	if sessions > 0 {
+		avgDuration := float64(duration) / 1000 / float64(sessions)
+		avgEvents := float64(events) / float64(sessions)
+		stats.AvgDurationSeconds = &avgDuration
+		stats.AvgEventsPerSession = &avgEvents
+	}
+	return stats
+}
@@ -0,0 +1,229 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"events-api/internal/constants"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kerimovok/go-pkg-utils/config"
+	"github.com/kerimovok/go-pkg-utils/httpx"
+)
+
+// Response headers stored and replayed along with the body
+var cachedHeaders = []string{fiber.HeaderContentType, constants.HeaderRollup}
+
+// Requests computing a response, closed once it is stored
+var (
+	inflightMu sync.Mutex
+	inflight   = map[string]chan struct{}{}
+)
+
+// New returns a middleware caching the successful responses of a route for ttl, disabled when ttl is 0
+//
+// Requests are identified by method, path and query parameters in any order, plus the body of
+// other than GET requests. Responses get an ETag and a Cache-Control max-age of the time left
+// until they expire, and GET requests with a matching If-None-Match get a 304 without a body.
+// Concurrent identical requests wait for the first one instead of all computing the response.
+//
+// The X-Cache response header is HIT, MISS or BYPASS. Passing noCache=true with the admin token
+// configured in CACHE_ADMIN_TOKEN in the X-Admin-Token header recomputes and stores the response.
+func New(ttl time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if ttl <= 0 {
+			return c.Next()
+		}
+
+		bypass := c.QueryBool(constants.ParamNoCache)
+		if bypass && !isAdmin(c) {
+			return httpx.SendResponse(c, httpx.Forbidden(fmt.Sprintf("%s requires a valid %s header", constants.ParamNoCache, constants.HeaderAdminToken)))
+		}
+
+		key := requestKey(c)
+		if !bypass {
+			entry, release := lookup(c.Context(), key)
+			if entry != nil {
+				return respond(c, entry, constants.CacheHit)
+			}
+			if release != nil {
+				defer release()
+			}
+		}
+
+		if err := c.Next(); err != nil {
+			return err
+		}
+
+		body := c.Response().Body()
+		if c.Response().StatusCode() != fiber.StatusOK || len(body) > constants.MaxCacheEntrySize {
+			return nil
+		}
+
+		now := time.Now()
+		sum := sha256.Sum256(body)
+		entry := &Entry{
+			Body:      append([]byte(nil), body...),
+			Headers:   map[string]string{},
+			ETag:      `W/"` + hex.EncodeToString(sum[:16]) + `"`,
+			StoredAt:  now,
+			ExpiresAt: now.Add(ttl),
+		}
+		for _, name := range cachedHeaders {
+			if value := c.GetRespHeader(name); value != "" {
+				entry.Headers[name] = value
+			}
+		}
+		if err := currentStore().Set(c.Context(), key, entry); err != nil {
+			log.Printf("failed to store cached response: %v", err)
+		}
+
+		status := constants.CacheMiss
+		if bypass {
+			status = constants.CacheBypass
+		}
+		return respond(c, entry, status)
+	}
+}
+
+// respond sends a cached response, or 304 Not Modified when the client has it already
+func respond(c *fiber.Ctx, entry *Entry, status string) error {
+	for name, value := range entry.Headers {
+		c.Set(name, value)
+	}
+	c.Set(fiber.HeaderETag, entry.ETag)
+	c.Set(fiber.HeaderCacheControl, fmt.Sprintf("public, max-age=%d", max(int(time.Until(entry.ExpiresAt).Seconds()), 0)))
+	c.Set(fiber.HeaderAge, strconv.Itoa(int(time.Since(entry.StoredAt).Seconds())))
+	c.Set(constants.HeaderCache, status)
+
+	if c.Method() == fiber.MethodGet && matchesETag(c.Get(fiber.HeaderIfNoneMatch), entry.ETag) {
+		c.Status(fiber.StatusNotModified)
+		c.Response().ResetBody()
+		return nil
+	}
+
+	c.Status(fiber.StatusOK)
+	c.Response().SetBody(entry.Body)
+	return nil
+}
+
+// matchesETag checks if an If-None-Match header lists etag, comparing tags weakly
+func matchesETag(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// requestKey identifies a request by method, path, query parameters and body
+// Parameters are sorted by name, keeping the order of repeated ones, and empty parameters and
+// the bypass parameter are left out, as the handlers ignore them. JSON bodies are re-encoded
+// with sorted keys and without whitespace, so that equivalent bodies share a key.
+func requestKey(c *fiber.Ctx) string {
+	var params [][2]string
+	c.Request().URI().QueryArgs().VisitAll(func(name, value []byte) {
+		if len(value) > 0 && string(name) != constants.ParamNoCache {
+			params = append(params, [2]string{string(name), string(value)})
+		}
+	})
+	sort.SliceStable(params, func(i, j int) bool {
+		return params[i][0] < params[j][0]
+	})
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", c.Method(), strings.TrimSuffix(c.Path(), "/"))
+	for _, param := range params {
+		fmt.Fprintf(hash, "%s=%s\n", url.QueryEscape(param[0]), url.QueryEscape(param[1]))
+	}
+	if c.Method() != fiber.MethodGet {
+		hash.Write(canonicalBody(c.Body()))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// canonicalBody re-encodes a JSON body with its object keys sorted, other bodies are returned as is
+// Numbers keep their text, as the handlers may tell 1 and 1.0 apart.
+func canonicalBody(body []byte) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil || decoder.More() {
+		return body
+	}
+	canonical, err := json.Marshal(value)
+	if err != nil {
+		return body
+	}
+	return canonical
+}
+
+// lookup returns the cached response of key, waiting for an identical request computing it
+// When the caller has to compute the response it gets a function to call once it is stored,
+// which wakes up the requests waiting for it.
+func lookup(ctx context.Context, key string) (*Entry, func()) {
+	for {
+		entry, err := currentStore().Get(ctx, key)
+		if err != nil {
+			log.Printf("failed to read cached response: %v", err)
+			return nil, nil
+		}
+		if entry != nil {
+			return entry, nil
+		}
+
+		wait, leader := claim(key)
+		if leader {
+			return nil, func() { release(key, wait) }
+		}
+		select {
+		case <-wait:
+			// Stored unless it failed, in which case one of the waiting requests computes it
+		case <-time.After(constants.CacheWaitTimeout):
+			return nil, nil
+		}
+	}
+}
+
+// isAdmin checks the admin token of a request, always false when no token is configured
+func isAdmin(c *fiber.Ctx) bool {
+	token := config.GetEnvOrDefault("CACHE_ADMIN_TOKEN", "")
+	given := c.Get(constants.HeaderAdminToken)
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(given)) == 1
+}
+
+// claim registers the caller as computing the response of key, unless another request is already
+// Returns the channel closed once the response of key is stored, and whether the caller computes it
+func claim(key string) (chan struct{}, bool) {
+	inflightMu.Lock()
+	defer inflightMu.Unlock()
+
+	if wait, ok := inflight[key]; ok {
+		return wait, false
+	}
+	wait := make(chan struct{})
+	inflight[key] = wait
+	return wait, true
+}
+
+// release wakes up the requests waiting for the response of key
+func release(key string, wait chan struct{}) {
+	inflightMu.Lock()
+	defer inflightMu.Unlock()
+
+	delete(inflight, key)
+	close(wait)
+}
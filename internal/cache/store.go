@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"events-api/internal/constants"
+	"sync"
+	"time"
+
+	"github.com/kerimovok/go-pkg-utils/config"
+)
+
+// Entry is a cached response
+type Entry struct {
+	Body      []byte            `json:"body"`
+	Headers   map[string]string `json:"headers"` // response headers replayed with the body, see cachedHeaders
+	ETag      string            `json:"etag"`
+	StoredAt  time.Time         `json:"stored_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// Store holds cached responses by key
+// The in-process LRU is used by default, SetStore plugs in a store shared between instances
+// such as Redis. Implementations must be safe for concurrent use, must not return entries past
+// their ExpiresAt and must not modify returned entries.
+type Store interface {
+	Get(ctx context.Context, key string) (*Entry, error) // nil without an error when missing
+	Set(ctx context.Context, key string, entry *Entry) error
+}
+
+var (
+	storeMu sync.RWMutex
+	store   Store
+)
+
+// SetStore replaces the store cached responses are kept in
+func SetStore(s Store) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	store = s
+}
+
+// currentStore returns the configured store, an LRU of CACHE_MAX_ENTRIES entries unless SetStore was called
+func currentStore() Store {
+	storeMu.RLock()
+	s := store
+	storeMu.RUnlock()
+	if s != nil {
+		return s
+	}
+
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	if store == nil {
+		store = NewLRU(config.GetEnvInt("CACHE_MAX_ENTRIES", constants.DefaultCacheMaxEntries))
+	}
+	return store
+}
+
+// TTL returns the TTL configured in seconds in an environment variable, see New
+func TTL(variable string, defaultSeconds int) time.Duration {
+	return time.Duration(config.GetEnvInt(variable, defaultSeconds)) * time.Second
+}
+
+// LRU is an in-process Store evicting the least recently used entries beyond its capacity
+type LRU struct {
+	mu       sync.Mutex
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List // most recently used first
+}
+
+type lruItem struct {
+	key   string
+	entry *Entry
+}
+
+// NewLRU creates an LRU holding at most capacity entries
+func NewLRU(capacity int) *LRU {
+	return &LRU{
+		capacity: max(capacity, 1),
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get returns the entry stored under key, nil when it is missing or expired
+func (l *LRU) Get(_ context.Context, key string) (*Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	element, ok := l.items[key]
+	if !ok {
+		return nil, nil
+	}
+	item := element.Value.(*lruItem)
+	if !time.Now().Before(item.entry.ExpiresAt) {
+		l.order.Remove(element)
+		delete(l.items, key)
+		return nil, nil
+	}
+
+	l.order.MoveToFront(element)
+	return item.entry, nil
+}
+
+// Set stores entry under key, evicting the least recently used entry when the LRU is full
+func (l *LRU) Set(_ context.Context, key string, entry *Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if element, ok := l.items[key]; ok {
+		element.Value.(*lruItem).entry = entry
+		l.order.MoveToFront(element)
+		return nil
+	}
+
+	l.items[key] = l.order.PushFront(&lruItem{key: key, entry: entry})
+	for l.order.Len() > l.capacity {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(*lruItem).key)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package constants
+
+import "time"
+
+const (
+	// Funnel limits
+	MinFunnelSteps      = 2
+	MaxFunnelSteps      = 10
+	DefaultFunnelWindow = "7d"
+	MaxFunnelWindow     = 90 * 24 * time.Hour
+
+	// Events of an actor walked by a funnel, the earliest ones are kept so that a single high
+	// volume actor can't grow past the document size limit
+	MaxFunnelEventsPerActor = 10000
+
+	// Retention defaults and limits
+	DefaultRetentionInterval = IntervalWeek
+	DefaultRetentionPeriods  = 8
+	MaxRetentionPeriods      = 52
+
+	// Path analysis directions and limits
+	PathDirectionBefore = "before"
+	PathDirectionAfter  = "after"
+	DefaultPathDepth    = 3
+	MaxPathDepth        = 10
+	DefaultPathLimit    = 20
+	MaxPathLimit        = 100
+
+	// Inactivity after which the next event of an actor starts a new session
+	DefaultSessionTimeout = "30m"
+	MaxSessionTimeout     = 24 * time.Hour
+
+	// Analytics queries scan many events, so they get more time than regular queries
+	AnalyticsTimeout = 2 * time.Minute
+)
@@ -0,0 +1,26 @@
+package constants
+
+const (
+	// Response cache defaults, TTLs are in seconds and 0 disables caching of the endpoints
+	DefaultCacheMaxEntries    = 1000
+	DefaultCacheTTLStats      = 30
+	DefaultCacheTTLTimeSeries = 30
+	DefaultCacheTTLAnalytics  = 300
+	DefaultCacheTTLSessions   = 60
+
+	// Responses larger than this are not cached
+	MaxCacheEntrySize = 4 << 20 // 4 MiB
+
+	// Recomputing a cached response requires the admin token configured in CACHE_ADMIN_TOKEN
+	ParamNoCache     = "noCache"
+	HeaderAdminToken = "X-Admin-Token"
+
+	// Response header telling where a response came from
+	HeaderCache = "X-Cache"
+	CacheHit    = "HIT"
+	CacheMiss   = "MISS"
+	CacheBypass = "BYPASS"
+
+	// How long a request waits for an identical one to compute the response before computing it too
+	CacheWaitTimeout = AnalyticsTimeout
+)
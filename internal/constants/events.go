@@ -8,27 +8,82 @@ const (
 	DefaultSortBy    = "created_at"
 	DefaultSortOrder = "asc"
 
+	// Pagination modes of the event list, offset (page and limit) unless cursor is asked for
+	PaginationOffset = "offset"
+	PaginationCursor = "cursor"
+
 	// Query parameter names
-	ParamPage       = "page"
-	ParamLimit      = "limit"
-	ParamSortBy     = "sortBy"
-	ParamSortOrder  = "sortOrder"
-	ParamGroupBy    = "groupBy"
-	ParamAggregates = "aggregates"
-	ParamInterval   = "interval"
-	ParamFilters    = "filters"
+	ParamPage         = "page"
+	ParamCursor       = "cursor"
+	ParamPagination   = "pagination"
+	ParamIncludeTotal = "includeTotal"
+	ParamLimit        = "limit"
+	ParamSortBy       = "sortBy"
+	ParamSortOrder    = "sortOrder"
+	ParamGroupBy      = "groupBy"
+	ParamAggregates   = "aggregates"
+	ParamInterval     = "interval"
+	ParamFilters      = "filters"
+	ParamName         = "name"
+	ParamTimeField    = "timeField"
+	ParamVersion      = "version"
+	ParamField        = "field"
+	ParamBreakdown    = "breakdown"
+	ParamTopN         = "topN"
+	ParamTimezone     = "timezone"
+	ParamFrom         = "from"
+	ParamTo           = "to"
+	ParamActor        = "actor"
+	ParamCompare      = "compare"
+	ParamCompareFrom  = "compareFrom"
+	ParamCompareTo    = "compareTo"
+	ParamWindow       = "window"
+	ParamTimeout      = "timeout"
+
+	// Time fields events can be sorted and bucketed on
+	TimeFieldCreatedAt  = "created_at"
+	TimeFieldOccurredAt = "occurred_at"
+	DefaultTimeField    = TimeFieldCreatedAt
+
+	// Accepted window for client supplied timestamps (seconds)
+	DefaultMaxEventPastAge    = 7 * 24 * 60 * 60
+	DefaultMaxEventFutureSkew = 5 * 60
 
 	// Default aggregation values
 	DefaultAggregates = "count"
 	DefaultInterval   = "day"
+	DefaultTimezone   = "UTC"
 
 	// Context timeout
 	QueryTimeout = 30 * time.Second
 
+	// Attempts at applying a patch before reporting a concurrent modification
+	MaxUpdateAttempts = 3
+
+	// Total count modes and the hard cap on counting
+	IncludeTotalExact     = "exact"
+	IncludeTotalEstimated = "estimated"
+	CountTimeout          = 5 * time.Second
+
 	// Pagination constants
 	DefaultPage  = 1
 	DefaultLimit = 50
 
+	// Batch ingestion constants
+	MaxBatchSize       = 1000
+	BatchStatusCreated = "created"
+	BatchStatusInvalid = "invalid"
+	BatchStatusFailed  = "failed"
+
+	// Largest request body read in memory, NDJSON streams are read line by line instead
+	MaxRequestBodySize = 4 << 20 // 4 MiB
+
+	// NDJSON stream ingestion constants
+	ContentTypeNDJSON    = "application/x-ndjson"
+	NDJSONChunkSize      = 500
+	MaxNDJSONLineSize    = 1 << 20 // 1 MiB
+	MaxNDJSONRejectLines = 1000
+
 	// Sort constants
 	SortAscending  = 1
 	SortDescending = -1
@@ -36,22 +91,109 @@ const (
 	SortOrderDesc  = "desc"
 
 	// Collection names
-	EventsCollection = "events"
+	EventsCollection          = "events"
+	IdempotencyKeysCollection = "idempotency_keys"
+	SchemasCollection         = "schemas"
+	RollupsCollection         = "rollups"
+	RollupsHourlyCollection   = "rollups_hourly"
+	RollupsDailyCollection    = "rollups_daily"
+
+	// Schema enforcement modes
+	SchemaModeReject  = "reject"
+	SchemaModeWarn    = "warn"
+	SchemaModeOff     = "off"
+	DefaultSchemaMode = SchemaModeReject
+
+	// How long compiled schemas are cached before being reloaded from the database
+	SchemaCacheTTL = 30 * time.Second
+
+	// Rollup granularities, build statuses and limits
+	RollupGranularityHour    = "hour"
+	RollupGranularityDay     = "day"
+	DefaultRollupGranularity = RollupGranularityHour
+	RollupStatusBuilding     = "building"
+	RollupStatusReady        = "ready"
+	MaxRollupDimensions      = 5
+	MaxRollupMetrics         = 10
+
+	// How long rollup definitions are cached before being reloaded from the database
+	// Events created that long after a rollup are counted on ingest by every instance
+	RollupCacheTTL = 30 * time.Second
+
+	// Time allowed to count the events created before a rollup into it
+	RollupBuildTimeout = time.Hour
+
+	// Delay before retrying a failed rollup build, doubled on every failure up to the maximum
+	RollupBuildRetryDelay    = time.Minute
+	MaxRollupBuildRetryDelay = time.Hour
+
+	// Times a rollup build counts the events again when some changed while it counted them
+	MaxRollupBackfillPasses = 5
+
+	// Response header naming the rollup stats and time series were answered from
+	HeaderRollup = "X-Events-Rollup"
+
+	// Idempotency constants
+	HeaderIdempotencyKey      = "Idempotency-Key"
+	HeaderIdempotencyReplayed = "Idempotency-Replayed"
+	AMQPHeaderIdempotencyKey  = "x-idempotency-key"
+	MaxIdempotencyKeyLength   = 255
+	DefaultIdempotencyKeyTTL  = 24 * 60 * 60 // seconds
+
+	// Age past which a claimed idempotency key whose event isn't stored is abandoned, requests
+	// claiming a key give up storing its event within QueryTimeout
+	IdempotencyClaimGracePeriod = QueryTimeout
+
+	// Maximum length of an event name in bytes, as requests.CreateEventRequest validates it
+	MaxEventNameLength = 255
+
+	// Maximum number of aggregations computed by one request
+	MaxAggregations = 10
+
+	// Stats grouping and time series breakdown limits
+	MaxGroupByFields = 5
+	DefaultTopN      = 10
+	MaxTopN          = 50
+
+	// Maximum number of buckets of a time series
+	MaxTimeSeriesBuckets = 10000
 
 	// Aggregation operations
-	AggregationCount = "count"
-	AggregationSum   = "sum"
-	AggregationAvg   = "avg"
+	AggregationCount               = "count"
+	AggregationSum                 = "sum"
+	AggregationAvg                 = "avg"
+	AggregationMin                 = "min"
+	AggregationMax                 = "max"
+	AggregationMedian              = "median"
+	AggregationP90                 = "p90"
+	AggregationP95                 = "p95"
+	AggregationP99                 = "p99"
+	AggregationStdDev              = "stddev"
+	AggregationCountDistinct       = "count_distinct"
+	AggregationApproxCountDistinct = "approx_count_distinct"
 
 	// Time intervals
-	IntervalHour  = "hour"
-	IntervalDay   = "day"
-	IntervalWeek  = "week"
-	IntervalMonth = "month"
-
-	// Time interval formats (for MongoDB dateToString)
-	TimeFormatHour  = "%Y-%m-%d-%H"
-	TimeFormatDay   = "%Y-%m-%d"
-	TimeFormatWeek  = "%Y-%U"
-	TimeFormatMonth = "%Y-%m"
+	IntervalMinute  = "minute"
+	IntervalHour    = "hour"
+	IntervalDay     = "day"
+	IntervalWeek    = "week"    // starts on Sunday, as with the former %U bucket format
+	IntervalISOWeek = "isoweek" // starts on Monday
+	IntervalMonth   = "month"
+	IntervalQuarter = "quarter"
+	IntervalYear    = "year"
+
+	// Period-over-period comparison modes
+	ComparePreviousPeriod = "previous_period"
+	ComparePreviousYear   = "previous_year"
+	CompareCustom         = "custom"
+
+	// Window operations over time series buckets
+	WindowMovingAvg  = "moving_avg"
+	WindowCumulative = "cumulative"
+	WindowRate       = "rate"
+	MaxWindowOps     = 5
+	MaxMovingAvgSize = 1000
+
+	// Largest multiple of a unit accepted by custom intervals such as 30m
+	MaxIntervalBinSize = 1000
 )
@@ -43,6 +43,68 @@ var EnvValidationRules = []validator.ValidationRule{
 		Message: "EVENT_PROCESSING_MODE must be 'rest-only', 'queue-only', or 'hybrid'",
 	},
 
+	// Idempotency configuration
+	{
+		Variable: "IDEMPOTENCY_KEY_TTL",
+		Default:  "86400",
+		Rule:     config.IsValidPositiveInteger,
+		Message:  "IDEMPOTENCY_KEY_TTL must be a positive number (seconds)",
+	},
+
+	// Client timestamp configuration
+	{
+		Variable: "EVENT_MAX_PAST_AGE",
+		Default:  "604800",
+		Rule:     config.IsValidPositiveInteger,
+		Message:  "EVENT_MAX_PAST_AGE must be a positive number (seconds)",
+	},
+	{
+		Variable: "EVENT_MAX_FUTURE_SKEW",
+		Default:  "300",
+		Rule:     config.IsValidNonNegativeInteger,
+		Message:  "EVENT_MAX_FUTURE_SKEW must be a non-negative number (seconds)",
+	},
+
+	// Event deletion configuration
+	{
+		Variable: "EVENT_SOFT_DELETE",
+		Default:  "false",
+		Rule:     func(v string) bool { return v == "true" || v == "false" },
+		Message:  "EVENT_SOFT_DELETE must be either 'true' or 'false'",
+	},
+
+	// Response cache configuration
+	{
+		Variable: "CACHE_MAX_ENTRIES",
+		Default:  "1000",
+		Rule:     config.IsValidPositiveInteger,
+		Message:  "CACHE_MAX_ENTRIES must be a positive number",
+	},
+	{
+		Variable: "CACHE_TTL_STATS",
+		Default:  "30",
+		Rule:     config.IsValidNonNegativeInteger,
+		Message:  "CACHE_TTL_STATS must be a non-negative number (seconds)",
+	},
+	{
+		Variable: "CACHE_TTL_TIMESERIES",
+		Default:  "30",
+		Rule:     config.IsValidNonNegativeInteger,
+		Message:  "CACHE_TTL_TIMESERIES must be a non-negative number (seconds)",
+	},
+	{
+		Variable: "CACHE_TTL_ANALYTICS",
+		Default:  "300",
+		Rule:     config.IsValidNonNegativeInteger,
+		Message:  "CACHE_TTL_ANALYTICS must be a non-negative number (seconds)",
+	},
+	{
+		Variable: "CACHE_TTL_SESSIONS",
+		Default:  "60",
+		Rule:     config.IsValidNonNegativeInteger,
+		Message:  "CACHE_TTL_SESSIONS must be a non-negative number (seconds)",
+	},
+
 	// Queue retry configuration
 	{
 		Variable: "QUEUE_MAX_RETRIES",
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"events-api/internal/constants"
+	"fmt"
+	"log"
+
+	"github.com/kerimovok/go-pkg-utils/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexOptionsConflictCode is returned when an index exists with the same keys but different options
+const indexOptionsConflictCode = 85
+
+// EnsureIndexes creates the indexes the service relies on
+// It is safe to call on every startup, existing indexes are left untouched
+func EnsureIndexes(ctx context.Context) error {
+	if err := ensureIndexes(ctx, constants.EventsCollection, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "name", Value: 1}, {Key: "created_at", Value: 1}},
+			Options: options.Index().SetName("name_created_at"),
+		},
+		{
+			Keys:    bson.D{{Key: "occurred_at", Value: 1}},
+			Options: options.Index().SetName("occurred_at"),
+		},
+	}); err != nil {
+		return err
+	}
+
+	if err := ensureIndexes(ctx, constants.SchemasCollection, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "name", Value: 1}, {Key: "version", Value: -1}},
+			Options: options.Index().SetName("name_version").SetUnique(true),
+		},
+	}); err != nil {
+		return err
+	}
+
+	if err := ensureIndexes(ctx, constants.RollupsCollection, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "name", Value: 1}},
+			Options: options.Index().SetName("name").SetUnique(true),
+		},
+	}); err != nil {
+		return err
+	}
+
+	// Rollup documents are upserted and merged on their rollup, generation, bucket and dimension values
+	for _, collection := range []string{constants.RollupsHourlyCollection, constants.RollupsDailyCollection} {
+		if err := ensureIndexes(ctx, collection, []mongo.IndexModel{
+			{
+				Keys:    bson.D{{Key: "r", Value: 1}, {Key: "g", Value: 1}, {Key: "b", Value: 1}, {Key: "d", Value: 1}},
+				Options: options.Index().SetName("rollup_generation_bucket_dimensions").SetUnique(true),
+			},
+		}); err != nil {
+			return err
+		}
+	}
+
+	idempotencyTTL := int32(config.GetEnvInt("IDEMPOTENCY_KEY_TTL", constants.DefaultIdempotencyKeyTTL))
+	if err := ensureTTLIndex(ctx, constants.IdempotencyKeysCollection, "created_at", idempotencyTTL); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ensureIndexes creates the given indexes on a collection
+func ensureIndexes(ctx context.Context, collectionName string, indexes []mongo.IndexModel) error {
+	collection := DBClient.Database().Collection(collectionName)
+	if _, err := collection.Indexes().CreateMany(ctx, indexes); err != nil {
+		return fmt.Errorf("failed to create indexes on %s: %w", collectionName, err)
+	}
+	return nil
+}
+
+// ensureTTLIndex creates a TTL index on field, updating the expiry of an existing one when it changed
+func ensureTTLIndex(ctx context.Context, collectionName, field string, expireAfterSeconds int32) error {
+	name := field + "_ttl"
+	collection := DBClient.Database().Collection(collectionName)
+
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: field, Value: 1}},
+		Options: options.Index().SetName(name).SetExpireAfterSeconds(expireAfterSeconds),
+	})
+	if err == nil {
+		return nil
+	}
+
+	var cmdErr mongo.CommandError
+	if !errors.As(err, &cmdErr) || cmdErr.Code != indexOptionsConflictCode {
+		return fmt.Errorf("failed to create TTL index on %s.%s: %w", collectionName, field, err)
+	}
+
+	// The index exists with a different expiry, update it in place
+	result := DBClient.Database().RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: collectionName},
+		{Key: "index", Value: bson.D{
+			{Key: "name", Value: name},
+			{Key: "expireAfterSeconds", Value: expireAfterSeconds},
+		}},
+	})
+	if err := result.Err(); err != nil {
+		return fmt.Errorf("failed to update TTL index on %s.%s: %w", collectionName, field, err)
+	}
+
+	log.Printf("updated TTL of index %s on %s to %d seconds", name, collectionName, expireAfterSeconds)
+	return nil
+}
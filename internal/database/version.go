@@ -0,0 +1,50 @@
+package database
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	versionMu    sync.Mutex
+	versionArray []int
+)
+
+// ServerVersion returns the version of the connected MongoDB server as [major, minor, patch, ...]
+// The version is looked up once and cached for the lifetime of the process
+func ServerVersion(ctx context.Context) ([]int, error) {
+	versionMu.Lock()
+	defer versionMu.Unlock()
+
+	if versionArray != nil {
+		return versionArray, nil
+	}
+
+	var info struct {
+		VersionArray []int `bson:"versionArray"`
+	}
+	if err := DBClient.Database().RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&info); err != nil {
+		return nil, err
+	}
+
+	versionArray = info.VersionArray
+	log.Printf("connected to MongoDB server version %v", versionArray)
+	return versionArray, nil
+}
+
+// SupportsVersion checks if the server is at least version major.minor
+// Servers whose version cannot be determined are treated as older ones
+func SupportsVersion(ctx context.Context, major, minor int) bool {
+	version, err := ServerVersion(ctx)
+	if err != nil {
+		log.Printf("failed to determine MongoDB server version: %v", err)
+		return false
+	}
+	if len(version) < 2 {
+		return false
+	}
+	return version[0] > major || (version[0] == major && version[1] >= minor)
+}
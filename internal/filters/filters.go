@@ -0,0 +1,393 @@
+// Package filters implements the filter language accepted by the query endpoints
+//
+// A filter is a JSON document made of clauses and logical groups:
+//
+//	{"field": "properties.country", "op": "eq", "value": "US"}
+//	{"and": [<filter>, ...]}, {"or": [<filter>, ...]}, {"not": <filter>}
+//
+// A top-level array is a shorthand for "and". Only allow-listed operators and field paths
+// are accepted, so callers can never reach MongoDB operators such as $where or $expr.
+package filters
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Limits protecting the database from pathological filters
+const (
+	MaxFilterSize   = 8 * 1024 // bytes of JSON
+	MaxDepth        = 5        // nesting of and/or/not groups
+	MaxClauses      = 50       // clauses and groups in total
+	MaxListValues   = 100      // values of an in/nin clause
+	MaxRegexLength  = 256
+	MaxPathSegments = 10
+	MaxPathLength   = 256
+)
+
+// Supported comparison operators
+const (
+	OpEq     = "eq"
+	OpNe     = "ne"
+	OpGt     = "gt"
+	OpGte    = "gte"
+	OpLt     = "lt"
+	OpLte    = "lte"
+	OpIn     = "in"
+	OpNin    = "nin"
+	OpExists = "exists"
+	OpRegex  = "regex"
+)
+
+var mongoOperators = map[string]string{
+	OpEq:  "$eq",
+	OpNe:  "$ne",
+	OpGt:  "$gt",
+	OpGte: "$gte",
+	OpLt:  "$lt",
+	OpLte: "$lte",
+	OpIn:  "$in",
+	OpNin: "$nin",
+}
+
+// Top-level event fields that can be filtered on besides properties.*
+var topLevelFields = map[string]bool{
+	"id":             true,
+	"name":           true,
+	"created_at":     true,
+	"updated_at":     true,
+	"occurred_at":    true,
+	"schema_version": true,
+}
+
+// Fields whose values are coerced to dates
+var dateFields = map[string]bool{
+	"created_at":  true,
+	"updated_at":  true,
+	"occurred_at": true,
+}
+
+var pathSegmentPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Error points to the clause of a filter that could not be accepted
+type Error struct {
+	Path    string // location of the clause, e.g. "filters.and[1].or[0]"
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// Parse parses a JSON filter and compiles it into a MongoDB query
+func Parse(raw string) (bson.M, error) {
+	if len(raw) > MaxFilterSize {
+		return nil, &Error{Message: fmt.Sprintf("filter must not be larger than %d bytes", MaxFilterSize)}
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.UseNumber()
+
+	var node interface{}
+	if err := decoder.Decode(&node); err != nil {
+		return nil, &Error{Message: fmt.Sprintf("invalid JSON: %v", err)}
+	}
+	if decoder.More() {
+		return nil, &Error{Message: "invalid JSON: unexpected data after filter"}
+	}
+
+	return Compile(node)
+}
+
+// Compile compiles an already decoded filter into a MongoDB query
+// Numbers should be decoded as json.Number to keep integers exact
+func Compile(node interface{}) (bson.M, error) {
+	p := &parser{}
+	if list, ok := node.([]interface{}); ok {
+		return p.group("filters", "and", list, 1)
+	}
+	return p.node("filters", node, 1)
+}
+
+// IsValidFieldPath checks if path is a property path (properties.a.b) or a filterable top-level field
+func IsValidFieldPath(path string) bool {
+	if topLevelFields[path] {
+		return true
+	}
+	return IsValidPropertyPath(path)
+}
+
+// IsValidGroupField checks if path can be grouped on, a field path other than the event id
+// Every event is a group of its own by id, which events store as _id.
+func IsValidGroupField(path string) bool {
+	return path != "id" && IsValidFieldPath(path)
+}
+
+// IsValidPropertyPath checks if path addresses a value inside the event properties
+func IsValidPropertyPath(path string) bool {
+	if len(path) > MaxPathLength || !strings.HasPrefix(path, "properties.") {
+		return false
+	}
+
+	segments := strings.Split(path, ".")
+	if len(segments) > MaxPathSegments {
+		return false
+	}
+	for _, segment := range segments {
+		if !pathSegmentPattern.MatchString(segment) {
+			return false
+		}
+	}
+	return true
+}
+
+type parser struct {
+	clauses int
+}
+
+func (p *parser) node(path string, node interface{}, depth int) (bson.M, error) {
+	if depth > MaxDepth {
+		return nil, &Error{Path: path, Message: fmt.Sprintf("filter must not be nested deeper than %d levels", MaxDepth)}
+	}
+
+	p.clauses++
+	if p.clauses > MaxClauses {
+		return nil, &Error{Path: path, Message: fmt.Sprintf("filter must not contain more than %d clauses", MaxClauses)}
+	}
+
+	object, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, &Error{Path: path, Message: "clause must be an object"}
+	}
+
+	for _, logical := range []string{"and", "or", "not"} {
+		value, ok := object[logical]
+		if !ok {
+			continue
+		}
+		if len(object) != 1 {
+			return nil, &Error{Path: path, Message: fmt.Sprintf("%q group must not contain other keys", logical)}
+		}
+		if logical == "not" {
+			inner, err := p.node(join(path, "not"), value, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			return bson.M{"$nor": bson.A{inner}}, nil
+		}
+		list, ok := value.([]interface{})
+		if !ok {
+			return nil, &Error{Path: join(path, logical), Message: fmt.Sprintf("%q must be an array of clauses", logical)}
+		}
+		return p.group(path, logical, list, depth+1)
+	}
+
+	return p.clause(path, object)
+}
+
+func (p *parser) group(path, logical string, list []interface{}, depth int) (bson.M, error) {
+	if len(list) == 0 {
+		return nil, &Error{Path: join(path, logical), Message: fmt.Sprintf("%q must contain at least one clause", logical)}
+	}
+
+	compiled := make(bson.A, len(list))
+	for i, item := range list {
+		clause, err := p.node(fmt.Sprintf("%s[%d]", join(path, logical), i), item, depth)
+		if err != nil {
+			return nil, err
+		}
+		compiled[i] = clause
+	}
+
+	if len(compiled) == 1 {
+		return compiled[0].(bson.M), nil
+	}
+	return bson.M{"$" + logical: compiled}, nil
+}
+
+func (p *parser) clause(path string, object map[string]interface{}) (bson.M, error) {
+	for key := range object {
+		if key != "field" && key != "op" && key != "value" && key != "options" {
+			return nil, &Error{Path: path, Message: fmt.Sprintf("unknown key %q, expected field, op, value or a and/or/not group", key)}
+		}
+	}
+
+	field, _ := object["field"].(string)
+	if !IsValidFieldPath(field) {
+		return nil, &Error{Path: path, Message: fmt.Sprintf("invalid field %q, expected a top-level field or a properties.* path", field)}
+	}
+	op, _ := object["op"].(string)
+	value, hasValue := object["value"]
+	if !hasValue {
+		return nil, &Error{Path: path, Message: "clause requires a value"}
+	}
+	if _, ok := object["options"]; ok && op != OpRegex {
+		return nil, &Error{Path: path, Message: "options are only supported by the regex operator"}
+	}
+
+	mongoField := field
+	if field == "id" {
+		mongoField = "_id"
+	}
+
+	switch op {
+	case OpEq, OpNe, OpGt, OpGte, OpLt, OpLte:
+		coerced, err := coerceValue(field, value)
+		if err != nil {
+			return nil, &Error{Path: path, Message: err.Error()}
+		}
+		return bson.M{mongoField: bson.M{mongoOperators[op]: coerced}}, nil
+
+	case OpIn, OpNin:
+		list, ok := value.([]interface{})
+		if !ok {
+			return nil, &Error{Path: path, Message: fmt.Sprintf("%s requires an array value", op)}
+		}
+		if len(list) > MaxListValues {
+			return nil, &Error{Path: path, Message: fmt.Sprintf("%s must not contain more than %d values", op, MaxListValues)}
+		}
+		values := make(bson.A, len(list))
+		for i, item := range list {
+			coerced, err := coerceValue(field, item)
+			if err != nil {
+				return nil, &Error{Path: fmt.Sprintf("%s.value[%d]", path, i), Message: err.Error()}
+			}
+			values[i] = coerced
+		}
+		return bson.M{mongoField: bson.M{mongoOperators[op]: values}}, nil
+
+	case OpExists:
+		exists, ok := value.(bool)
+		if !ok {
+			return nil, &Error{Path: path, Message: "exists requires a boolean value"}
+		}
+		return bson.M{mongoField: bson.M{"$exists": exists}}, nil
+
+	case OpRegex:
+		return regexClause(path, mongoField, value, object["options"])
+
+	default:
+		return nil, &Error{Path: path, Message: fmt.Sprintf("unsupported operator %q, expected one of eq, ne, gt, gte, lt, lte, in, nin, exists, regex", op)}
+	}
+}
+
+// regexClause builds a $regex clause
+// Patterns must be valid RE2 expressions, which rules out backreferences and lookarounds. The
+// server matches them with a backtracking engine though, so quantified groups that contain a
+// quantifier or an alternation, such as (a+)+ or (a|ab)*, which can take it exponential time,
+// are rejected as well.
+func regexClause(path, field string, value, options interface{}) (bson.M, error) {
+	pattern, ok := value.(string)
+	if !ok {
+		return nil, &Error{Path: path, Message: "regex requires a string value"}
+	}
+	if len(pattern) > MaxRegexLength {
+		return nil, &Error{Path: path, Message: fmt.Sprintf("regex must not be longer than %d characters", MaxRegexLength)}
+	}
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, &Error{Path: path, Message: fmt.Sprintf("invalid regex: %v", err)}
+	}
+	if hasNestedRepetition(parsed, false) {
+		return nil, &Error{Path: path, Message: "regex must not repeat a group containing a quantifier or an alternation"}
+	}
+
+	flags := ""
+	if options != nil {
+		flags, ok = options.(string)
+		if !ok || strings.Trim(flags, "ims") != "" {
+			return nil, &Error{Path: path, Message: "regex options may only contain i, m and s"}
+		}
+	}
+
+	return bson.M{field: bson.M{"$regex": primitive.Regex{Pattern: pattern, Options: flags}}}, nil
+}
+
+// hasNestedRepetition reports whether a quantifier applies to an expression that contains
+// another quantifier or an alternation, repeated tells whether an enclosing one does
+func hasNestedRepetition(re *syntax.Regexp, repeated bool) bool {
+	switch re.Op {
+	case syntax.OpStar, syntax.OpPlus, syntax.OpRepeat:
+		if re.Op == syntax.OpRepeat && re.Max >= 0 && re.Max <= 1 {
+			break
+		}
+		if repeated {
+			return true
+		}
+		repeated = true
+	case syntax.OpAlternate:
+		if repeated {
+			return true
+		}
+	}
+
+	for _, sub := range re.Sub {
+		if hasNestedRepetition(sub, repeated) {
+			return true
+		}
+	}
+	return false
+}
+
+// coerceValue converts a scalar JSON value into the value stored for field
+// Objects are rejected so that values can never smuggle in query operators
+func coerceValue(field string, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil, bool:
+		return v, nil
+	case json.Number:
+		if dateFields[field] {
+			millis, err := v.Int64()
+			if err != nil {
+				return nil, fmt.Errorf("%s must be an RFC3339 date or epoch milliseconds", field)
+			}
+			return time.UnixMilli(millis).UTC(), nil
+		}
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	case string:
+		if dateFields[field] {
+			return parseDate(field, v)
+		}
+		if field == "id" {
+			id, err := primitive.ObjectIDFromHex(v)
+			if err != nil {
+				return nil, fmt.Errorf("id must be a valid ObjectID")
+			}
+			return id, nil
+		}
+		return v, nil
+	default:
+		return nil, fmt.Errorf("value must be a string, number, boolean or null")
+	}
+}
+
+// parseDate accepts RFC3339 timestamps and plain dates (2006-01-02, UTC)
+func parseDate(field, value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%s must be an RFC3339 date or epoch milliseconds", field)
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
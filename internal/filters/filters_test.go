@@ -0,0 +1,214 @@
+package filters
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp/syntax"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// nested returns a filter of depth levels of not groups around a clause
+func nested(depth int) string {
+	filter := `{"field": "name", "op": "eq", "value": "signup"}`
+	for i := 1; i < depth; i++ {
+		filter = `{"not": ` + filter + `}`
+	}
+	return filter
+}
+
+// clauses returns an and group of n clauses
+func clauses(n int) string {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = fmt.Sprintf(`{"field": "properties.n", "op": "eq", "value": %d}`, i)
+	}
+	return `{"and": [` + strings.Join(list, ",") + `]}`
+}
+
+// values returns an in clause of n values
+func values(n int) string {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = fmt.Sprint(i)
+	}
+	return `{"field": "properties.n", "op": "in", "value": [` + strings.Join(list, ",") + `]}`
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		want   bson.M
+	}{
+		{
+			name:   "comparison",
+			filter: `{"field": "properties.amount", "op": "gte", "value": 10}`,
+			want:   bson.M{"properties.amount": bson.M{"$gte": int64(10)}},
+		},
+		{
+			name:   "top-level array is an and group",
+			filter: `[{"field": "name", "op": "eq", "value": "a"}, {"field": "name", "op": "ne", "value": "b"}]`,
+			want: bson.M{"$and": bson.A{
+				bson.M{"name": bson.M{"$eq": "a"}},
+				bson.M{"name": bson.M{"$ne": "b"}},
+			}},
+		},
+		{
+			name:   "not group",
+			filter: `{"not": {"field": "properties.plan", "op": "exists", "value": true}}`,
+			want:   bson.M{"$nor": bson.A{bson.M{"properties.plan": bson.M{"$exists": true}}}},
+		},
+		{
+			name:   "id is mapped to _id",
+			filter: `{"field": "id", "op": "eq", "value": "64b7f0c2a1b2c3d4e5f60718"}`,
+			want:   bson.M{"_id": bson.M{"$eq": mustObjectID("64b7f0c2a1b2c3d4e5f60718")}},
+		},
+		{
+			name:   "dollar string value is a literal",
+			filter: `{"field": "properties.price", "op": "eq", "value": "$100"}`,
+			want:   bson.M{"properties.price": bson.M{"$eq": "$100"}},
+		},
+		{
+			name:   "regex with options",
+			filter: `{"field": "properties.email", "op": "regex", "value": "^[a-z]+@example\\.com$", "options": "i"}`,
+			want:   bson.M{"properties.email": bson.M{"$regex": primitive.Regex{Pattern: `^[a-z]+@example\.com$`, Options: "i"}}},
+		},
+		{
+			name:   "deepest nesting allowed",
+			filter: nested(MaxDepth),
+			want:   nestedWant(MaxDepth),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.filter)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		message string
+	}{
+		{"too large", `{"field": "name", "op": "eq", "value": "` + strings.Repeat("a", MaxFilterSize) + `"}`, "must not be larger than"},
+		{"too deep", nested(MaxDepth + 1), "must not be nested deeper than"},
+		{"too many clauses", clauses(MaxClauses), "must not contain more than"},
+		{"too many values", values(MaxListValues + 1), "must not contain more than"},
+		{"unknown operator", `{"field": "name", "op": "where", "value": "1"}`, "unsupported operator"},
+		{"mongo operator as op", `{"field": "name", "op": "$where", "value": "1"}`, "unsupported operator"},
+		{"mongo operator as key", `{"field": "name", "op": "eq", "value": "a", "$where": "1"}`, "unknown key"},
+		{"mongo operator as field", `{"field": "$where", "op": "eq", "value": "1"}`, "invalid field"},
+		{"dollar path segment", `{"field": "properties.$where", "op": "eq", "value": "1"}`, "invalid field"},
+		{"unlisted top-level field", `{"field": "deleted_at", "op": "exists", "value": true}`, "invalid field"},
+		{"operator object as value", `{"field": "properties.plan", "op": "eq", "value": {"$ne": null}}`, "value must be"},
+		{"operator object in list", `{"field": "properties.plan", "op": "in", "value": [{"$gt": ""}]}`, "value must be"},
+		{"group with other keys", `{"and": [], "field": "name"}`, "must not contain other keys"},
+		{"empty group", `{"or": []}`, "must contain at least one clause"},
+		{"options on other operators", `{"field": "name", "op": "eq", "value": "a", "options": "i"}`, "only supported by the regex operator"},
+		{"invalid regex", `{"field": "name", "op": "regex", "value": "("}`, "invalid regex"},
+		{"nested quantifiers", `{"field": "name", "op": "regex", "value": "(a+)+$"}`, "must not repeat a group"},
+		{"repeated alternation", `{"field": "name", "op": "regex", "value": "(a|ab)*c"}`, "must not repeat a group"},
+		{"backreference", `{"field": "name", "op": "regex", "value": "(a)\\1"}`, "invalid regex"},
+		{"regex options", `{"field": "name", "op": "regex", "value": "a", "options": "x"}`, "may only contain"},
+		{"trailing data", `{"field": "name", "op": "eq", "value": "a"} {}`, "unexpected data"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.filter)
+			var filterErr *Error
+			if !errors.As(err, &filterErr) {
+				t.Fatalf("Parse() error = %v, want a filter error", err)
+			}
+			if !strings.Contains(filterErr.Message, tt.message) {
+				t.Errorf("Parse() error = %q, want it to contain %q", filterErr.Message, tt.message)
+			}
+		})
+	}
+}
+
+func TestHasNestedRepetition(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    bool
+	}{
+		{`^signup`, false},
+		{`checkout.*completed`, false},
+		{`(ab)+`, false},
+		{`(?:a|b)+`, false}, // simplified to a character class
+		{`[a-z]+@example\.com`, false},
+		{`(a*)?`, false},
+		{`(a+)+$`, true},
+		{`(a*)*`, true},
+		{`(a|ab)*`, true},
+		{`(a{2,5})+`, true},
+		{`((ab)+c)*`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			re, err := syntax.Parse(tt.pattern, syntax.Perl)
+			if err != nil {
+				t.Fatalf("parse %q: %v", tt.pattern, err)
+			}
+			if got := hasNestedRepetition(re, false); got != tt.want {
+				t.Errorf("hasNestedRepetition(%q) = %v, want %v", tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsValidGroupField(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"name", true},
+		{"created_at", true},
+		{"properties.country", true},
+		{"properties.id", true},
+		{"id", false}, // stored as _id, grouping on $id would yield a single null group
+		{"_id", false},
+		{"deleted_at", false},
+		{"properties.$where", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := IsValidGroupField(tt.path); got != tt.want {
+				t.Errorf("IsValidGroupField(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func nestedWant(depth int) bson.M {
+	want := bson.M{"name": bson.M{"$eq": "signup"}}
+	for i := 1; i < depth; i++ {
+		want = bson.M{"$nor": bson.A{want}}
+	}
+	return want
+}
+
+func mustObjectID(hex string) primitive.ObjectID {
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
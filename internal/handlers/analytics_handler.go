@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"context"
+	"events-api/internal/analytics"
+	"events-api/internal/constants"
+	queryFilters "events-api/internal/filters"
+	"events-api/internal/requests"
+	internalUtils "events-api/internal/utils"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kerimovok/go-pkg-utils/httpx"
+	"github.com/kerimovok/go-pkg-utils/validator"
+)
+
+// GetFunnel computes a conversion funnel over the stored events
+// The request body lists the ordered steps (event name and optional filters), the property
+// identifying actors, the conversion window and an optional time range, see requests.FunnelRequest.
+// Returns per-step counts, conversion rates and the median time between consecutive steps.
+func GetFunnel(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.AnalyticsTimeout)
+	defer cancel()
+
+	var input requests.FunnelRequest
+	if err := c.BodyParser(&input); err != nil {
+		log.Printf("failed to parse request body: %v", err)
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid request body", err))
+	}
+
+	funnel, validationErrors := buildFunnel(&input)
+	if validationErrors.HasErrors() {
+		log.Printf("validation failed for funnel: %v", validationErrors)
+		response := httpx.UnprocessableEntityWithValidation("Validation failed", toHTTPValidationErrors(validationErrors))
+		return httpx.SendValidationResponse(c, response)
+	}
+
+	steps, err := analytics.ComputeFunnel(ctx, funnel)
+	if err != nil {
+		log.Printf("failed to compute funnel: %v", err)
+		return httpx.SendResponse(c, httpx.InternalServerError("Failed to compute funnel", err))
+	}
+
+	return httpx.SendResponse(c, httpx.OK("Funnel computed successfully", fiber.Map{
+		"actor":      funnel.Actor,
+		"window":     input.Window,
+		"time_field": funnel.TimeField,
+		"steps":      steps,
+	}))
+}
+
+// buildFunnel validates a funnel request and compiles its step filters
+func buildFunnel(input *requests.FunnelRequest) (analytics.Funnel, validator.ValidationErrors) {
+	validationErrors := validator.ValidateStruct(input)
+
+	if len(input.Steps) < constants.MinFunnelSteps || len(input.Steps) > constants.MaxFunnelSteps {
+		validationErrors = append(validationErrors, validator.FieldError{
+			Field:   "steps",
+			Message: fmt.Sprintf("a funnel must have between %d and %d steps", constants.MinFunnelSteps, constants.MaxFunnelSteps),
+		})
+	}
+
+	steps := make([]analytics.EventMatch, len(input.Steps))
+	for i, step := range input.Steps {
+		var stepErrors validator.ValidationErrors
+		steps[i], stepErrors = buildEventMatch(fmt.Sprintf("steps[%d]", i), step)
+		validationErrors = append(validationErrors, stepErrors...)
+	}
+
+	if input.Actor != "" && !queryFilters.IsValidFieldPath(input.Actor) {
+		validationErrors = append(validationErrors, validator.FieldError{
+			Field:   "actor",
+			Message: "actor must be a top-level field or a properties.* path",
+			Value:   input.Actor,
+		})
+	}
+
+	if input.Window == "" {
+		input.Window = constants.DefaultFunnelWindow
+	}
+	window, err := analytics.ParseWindow(input.Window)
+	if err != nil {
+		validationErrors = append(validationErrors, validator.FieldError{Field: "window", Message: err.Error(), Value: input.Window})
+	}
+
+	if input.TimeField == "" {
+		input.TimeField = constants.DefaultTimeField
+	}
+	if !isValidTimeField(input.TimeField) {
+		validationErrors = append(validationErrors, validator.FieldError{
+			Field:   "time_field",
+			Message: "time_field must be 'created_at' or 'occurred_at'",
+			Value:   input.TimeField,
+		})
+	}
+
+	funnel := analytics.Funnel{
+		Steps:     steps,
+		Actor:     input.Actor,
+		Window:    window,
+		TimeField: input.TimeField,
+	}
+	if input.From != nil {
+		funnel.From = input.From.Time
+	}
+	if input.To != nil {
+		funnel.To = input.To.Time
+	}
+	if !funnel.From.IsZero() && !funnel.To.IsZero() && !funnel.From.Before(funnel.To) {
+		validationErrors = append(validationErrors, validator.FieldError{Field: "to", Message: "to must be after from"})
+	}
+
+	return funnel, validationErrors
+}
+
+// GetRetention computes a cohort retention matrix over the stored events
+// Actors are grouped into cohorts by the period of their first start event, and counted as retained
+// in every later period they perform the return event in, see requests.RetentionRequest.
+// Returns one row per cohort with its size and the absolute and relative number of retained actors.
+func GetRetention(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.AnalyticsTimeout)
+	defer cancel()
+
+	var input requests.RetentionRequest
+	if err := c.BodyParser(&input); err != nil {
+		log.Printf("failed to parse request body: %v", err)
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid request body", err))
+	}
+
+	retention, validationErrors := buildRetention(&input)
+	if validationErrors.HasErrors() {
+		log.Printf("validation failed for retention: %v", validationErrors)
+		response := httpx.UnprocessableEntityWithValidation("Validation failed", toHTTPValidationErrors(validationErrors))
+		return httpx.SendValidationResponse(c, response)
+	}
+
+	cohorts, err := analytics.ComputeRetention(ctx, retention)
+	if err != nil {
+		log.Printf("failed to compute retention: %v", err)
+		return httpx.SendResponse(c, httpx.InternalServerError("Failed to compute retention", err))
+	}
+
+	return httpx.SendResponse(c, httpx.OK("Retention computed successfully", fiber.Map{
+		"actor":      retention.Actor,
+		"interval":   input.Interval,
+		"periods":    retention.Periods,
+		"time_field": input.TimeField,
+		"timezone":   input.Timezone,
+		"cohorts":    cohorts,
+	}))
+}
+
+// Intervals cohorts can be formed on
+var retentionIntervals = map[string]bool{
+	constants.IntervalDay:     true,
+	constants.IntervalWeek:    true,
+	constants.IntervalISOWeek: true,
+	constants.IntervalMonth:   true,
+}
+
+// buildRetention validates a retention request and compiles its event filters
+func buildRetention(input *requests.RetentionRequest) (analytics.Retention, validator.ValidationErrors) {
+	validationErrors := validator.ValidateStruct(input)
+
+	startMatch, startErrors := buildEventMatch("start_event", input.StartEvent)
+	validationErrors = append(validationErrors, startErrors...)
+	returnMatch, returnErrors := buildEventMatch("return_event", input.ReturnEvent)
+	validationErrors = append(validationErrors, returnErrors...)
+
+	if input.Actor != "" && !queryFilters.IsValidFieldPath(input.Actor) {
+		validationErrors = append(validationErrors, validator.FieldError{
+			Field:   "actor",
+			Message: "actor must be a top-level field or a properties.* path",
+			Value:   input.Actor,
+		})
+	}
+
+	if input.Interval == "" {
+		input.Interval = constants.DefaultRetentionInterval
+	}
+	interval, err := internalUtils.ParseInterval(input.Interval)
+	if err != nil || !retentionIntervals[input.Interval] {
+		validationErrors = append(validationErrors, validator.FieldError{
+			Field:   "interval",
+			Message: "interval must be 'day', 'week', 'isoweek' or 'month'",
+			Value:   input.Interval,
+		})
+	}
+
+	if input.Periods == 0 {
+		input.Periods = constants.DefaultRetentionPeriods
+	}
+	if input.Periods < 1 || input.Periods > constants.MaxRetentionPeriods {
+		validationErrors = append(validationErrors, validator.FieldError{
+			Field:   "periods",
+			Message: fmt.Sprintf("periods must be between 1 and %d", constants.MaxRetentionPeriods),
+			Value:   strconv.Itoa(input.Periods),
+		})
+	}
+
+	if input.TimeField == "" {
+		input.TimeField = constants.DefaultTimeField
+	}
+	if !isValidTimeField(input.TimeField) {
+		validationErrors = append(validationErrors, validator.FieldError{
+			Field:   "time_field",
+			Message: "time_field must be 'created_at' or 'occurred_at'",
+			Value:   input.TimeField,
+		})
+	}
+
+	if input.Timezone == "" {
+		input.Timezone = constants.DefaultTimezone
+	}
+	location, err := loadTimezone(input.Timezone)
+	if err != nil {
+		validationErrors = append(validationErrors, validator.FieldError{
+			Field:   "timezone",
+			Message: "timezone must be an IANA time zone name such as Europe/Berlin",
+			Value:   input.Timezone,
+		})
+	}
+
+	retention := analytics.Retention{
+		Start:  startMatch,
+		Return: returnMatch,
+		Actor:  input.Actor,
+		Bucketing: internalUtils.TimeBucketing{
+			Interval:  interval,
+			TimeField: input.TimeField,
+			Location:  location,
+		},
+		Periods: input.Periods,
+	}
+	if input.From != nil {
+		retention.Bucketing.From = input.From.Time
+	}
+	if input.To != nil {
+		retention.Bucketing.To = input.To.Time
+	}
+	if !retention.Bucketing.From.IsZero() && !retention.Bucketing.To.IsZero() && !retention.Bucketing.From.Before(retention.Bucketing.To) {
+		validationErrors = append(validationErrors, validator.FieldError{Field: "to", Message: "to must be after from"})
+	}
+
+	return retention, validationErrors
+}
+
+// GetPaths finds the most frequent event sequences right before or after an anchor event
+// The request body names the anchor event, the direction, the number of events in a path and
+// the property identifying actors, see requests.PathsRequest.
+// Returns the paths with the number of anchors and distinct actors they were found for.
+func GetPaths(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.AnalyticsTimeout)
+	defer cancel()
+
+	var input requests.PathsRequest
+	if err := c.BodyParser(&input); err != nil {
+		log.Printf("failed to parse request body: %v", err)
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid request body", err))
+	}
+
+	paths, validationErrors := buildPaths(&input)
+	if validationErrors.HasErrors() {
+		log.Printf("validation failed for paths: %v", validationErrors)
+		response := httpx.UnprocessableEntityWithValidation("Validation failed", toHTTPValidationErrors(validationErrors))
+		return httpx.SendValidationResponse(c, response)
+	}
+
+	results, anchors, err := analytics.ComputePaths(ctx, paths)
+	if err != nil {
+		log.Printf("failed to compute paths: %v", err)
+		return httpx.SendResponse(c, httpx.InternalServerError("Failed to compute paths", err))
+	}
+
+	return httpx.SendResponse(c, httpx.OK("Paths computed successfully", fiber.Map{
+		"anchor":     paths.Anchor.Name,
+		"direction":  paths.Direction,
+		"depth":      paths.Depth,
+		"actor":      paths.Actor,
+		"time_field": paths.TimeField,
+		"anchors":    anchors,
+		"paths":      results,
+	}))
+}
+
+// buildPaths validates a paths request and compiles its anchor filters
+func buildPaths(input *requests.PathsRequest) (analytics.Paths, validator.ValidationErrors) {
+	validationErrors := validator.ValidateStruct(input)
+
+	anchor, anchorErrors := buildEventMatch("anchor", input.Anchor)
+	validationErrors = append(validationErrors, anchorErrors...)
+
+	if input.Actor != "" && !queryFilters.IsValidFieldPath(input.Actor) {
+		validationErrors = append(validationErrors, validator.FieldError{
+			Field:   "actor",
+			Message: "actor must be a top-level field or a properties.* path",
+			Value:   input.Actor,
+		})
+	}
+
+	if input.Direction == "" {
+		input.Direction = constants.PathDirectionAfter
+	}
+	if input.Direction != constants.PathDirectionBefore && input.Direction != constants.PathDirectionAfter {
+		validationErrors = append(validationErrors, validator.FieldError{
+			Field:   "direction",
+			Message: "direction must be 'before' or 'after'",
+			Value:   input.Direction,
+		})
+	}
+
+	if input.Depth == 0 {
+		input.Depth = constants.DefaultPathDepth
+	}
+	if input.Depth < 1 || input.Depth > constants.MaxPathDepth {
+		validationErrors = append(validationErrors, validator.FieldError{
+			Field:   "depth",
+			Message: fmt.Sprintf("depth must be between 1 and %d", constants.MaxPathDepth),
+			Value:   strconv.Itoa(input.Depth),
+		})
+	}
+
+	if input.Limit == 0 {
+		input.Limit = constants.DefaultPathLimit
+	}
+	if input.Limit < 1 || input.Limit > constants.MaxPathLimit {
+		validationErrors = append(validationErrors, validator.FieldError{
+			Field:   "limit",
+			Message: fmt.Sprintf("limit must be between 1 and %d", constants.MaxPathLimit),
+			Value:   strconv.Itoa(input.Limit),
+		})
+	}
+
+	if input.TimeField == "" {
+		input.TimeField = constants.DefaultTimeField
+	}
+	if !isValidTimeField(input.TimeField) {
+		validationErrors = append(validationErrors, validator.FieldError{
+			Field:   "time_field",
+			Message: "time_field must be 'created_at' or 'occurred_at'",
+			Value:   input.TimeField,
+		})
+	}
+
+	paths := analytics.Paths{
+		Anchor:    anchor,
+		Direction: input.Direction,
+		Depth:     input.Depth,
+		Actor:     input.Actor,
+		TimeField: input.TimeField,
+		Limit:     input.Limit,
+	}
+	if input.From != nil {
+		paths.From = input.From.Time
+	}
+	if input.To != nil {
+		paths.To = input.To.Time
+	}
+	if !paths.From.IsZero() && !paths.To.IsZero() && !paths.From.Before(paths.To) {
+		validationErrors = append(validationErrors, validator.FieldError{Field: "to", Message: "to must be after from"})
+	}
+
+	return paths, validationErrors
+}
+
+// buildEventMatch validates an event selection of an analytics request and compiles its filters
+// field is the location of the selection in the request, used in validation errors
+func buildEventMatch(field string, input requests.EventMatchRequest) (analytics.EventMatch, validator.ValidationErrors) {
+	var validationErrors validator.ValidationErrors
+
+	if input.Name == "" {
+		validationErrors = append(validationErrors, validator.FieldError{Field: field + ".name", Message: "name is required"})
+	}
+	match := analytics.EventMatch{Name: input.Name}
+
+	if len(input.Filters) > 0 && string(input.Filters) != "null" {
+		filters, err := queryFilters.Parse(string(input.Filters))
+		if err != nil {
+			validationErrors = append(validationErrors, validator.FieldError{Field: field + ".filters", Message: err.Error()})
+		}
+		match.Filters = filters
+	}
+
+	return match, validationErrors
+}
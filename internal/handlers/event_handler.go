@@ -1,27 +1,42 @@
 package handlers
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"events-api/internal/constants"
 	"events-api/internal/database"
+	queryFilters "events-api/internal/filters"
 	"events-api/internal/models"
 	"events-api/internal/requests"
+	"events-api/internal/rollups"
+	"events-api/internal/schemas"
 	internalUtils "events-api/internal/utils"
 	"fmt"
+	"io"
 	"log"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/kerimovok/go-pkg-utils/config"
 	"github.com/kerimovok/go-pkg-utils/httpx"
 	"github.com/kerimovok/go-pkg-utils/validator"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// CreateEvent creates a single event
+// An optional Idempotency-Key header makes retries safe: a repeated key within the
+// expiry window returns the originally created event instead of inserting a copy
 func CreateEvent(c *fiber.Ctx) error {
-	ctx := c.Context()
+	// Bounded so that a claimed idempotency key is abandoned after IdempotencyClaimGracePeriod
+	ctx, cancel := context.WithTimeout(context.Background(), constants.QueryTimeout)
+	defer cancel()
 	var input requests.CreateEventRequest
 
 	if err := c.BodyParser(&input); err != nil {
@@ -30,54 +45,345 @@ func CreateEvent(c *fiber.Ctx) error {
 		return httpx.SendResponse(c, response)
 	}
 
-	validationErrors := validator.ValidateStruct(&input)
+	event, validationErrors, err := prepareEvent(ctx, &input)
+	if err != nil {
+		log.Printf("failed to prepare event: %v", err)
+		return httpx.SendResponse(c, httpx.InternalServerError("Failed to create event", err))
+	}
 	if validationErrors.HasErrors() {
 		log.Printf("validation failed for event creation: %v", validationErrors)
-		// Convert validator.ValidationErrors to []httpx.ValidationError
-		httpxErrors := make([]httpx.ValidationError, len(validationErrors))
-		for i, err := range validationErrors {
-			httpxErrors[i] = httpx.ValidationError{
-				Field:   err.Field,
-				Message: err.Message,
-			}
-		}
-		response := httpx.UnprocessableEntityWithValidation("Validation failed", httpxErrors)
+		response := httpx.UnprocessableEntityWithValidation("Validation failed", toHTTPValidationErrors(validationErrors))
 		return httpx.SendValidationResponse(c, response)
 	}
 
-	event := models.Event{
-		Id:         primitive.NewObjectID(),
-		Properties: input.Properties,
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
+	idempotencyKey := c.Get(constants.HeaderIdempotencyKey)
+	if len(idempotencyKey) > constants.MaxIdempotencyKeyLength {
+		return httpx.SendResponse(c, httpx.BadRequest(fmt.Sprintf("%s must not be longer than %d characters", constants.HeaderIdempotencyKey, constants.MaxIdempotencyKeyLength), nil))
+	}
+	if idempotencyKey != "" {
+		existing, err := internalUtils.ClaimIdempotencyKey(ctx, idempotencyKey, event.Id)
+		if errors.Is(err, internalUtils.ErrIdempotencyKeyInProgress) {
+			return httpx.SendResponse(c, httpx.Conflict("Event creation already in progress", err))
+		}
+		if err != nil {
+			log.Printf("failed to claim idempotency key: %v", err)
+			return httpx.SendResponse(c, httpx.InternalServerError("Failed to create event", err))
+		}
+		if existing != nil {
+			log.Printf("idempotency key replayed, returning event with ID: %s", existing.Id.Hex())
+			c.Set(constants.HeaderIdempotencyReplayed, "true")
+			return httpx.SendResponse(c, httpx.OK("Event already created", existing))
+		}
 	}
 
 	result, err := database.DBClient.Database().Collection(constants.EventsCollection).InsertOne(ctx, event)
 	if err != nil {
 		log.Printf("failed to create event in database: %v", err)
+		if idempotencyKey != "" {
+			if releaseErr := internalUtils.ReleaseIdempotencyKey(ctx, idempotencyKey); releaseErr != nil {
+				log.Printf("failed to release idempotency key: %v", releaseErr)
+			}
+		}
 		response := httpx.InternalServerError("Failed to create event", err)
 		return httpx.SendResponse(c, response)
 	}
 
 	event.Id = result.InsertedID.(primitive.ObjectID)
 	log.Printf("event created successfully with ID: %s", event.Id.Hex())
+	recordRollups(ctx, event)
 
 	response := httpx.Created("Event created successfully", event)
 	return httpx.SendResponse(c, response)
 }
 
+// BatchItemResult reports the outcome of a single item of a batch ingestion request
+type BatchItemResult struct {
+	Index  int                     `json:"index"`
+	Status string                  `json:"status"`
+	Id     string                  `json:"id,omitempty"`
+	Error  string                  `json:"error,omitempty"`
+	Errors []httpx.ValidationError `json:"validation_errors,omitempty"`
+}
+
+// CreateEventsBatch creates multiple events from a JSON array in a single request
+// Every item is validated individually and the valid ones are written with one unordered
+// InsertMany, so invalid or failed items are reported per index without sinking the whole batch
+func CreateEventsBatch(c *fiber.Ctx) error {
+	ctx := c.Context()
+	var inputs []requests.CreateEventRequest
+
+	if err := c.BodyParser(&inputs); err != nil {
+		log.Printf("failed to parse batch request body: %v", err)
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid request body", err))
+	}
+	if len(inputs) == 0 {
+		return httpx.SendResponse(c, httpx.BadRequest("Batch must contain at least one event", nil))
+	}
+	if len(inputs) > constants.MaxBatchSize {
+		return httpx.SendResponse(c, httpx.BadRequest(fmt.Sprintf("Batch must not contain more than %d events", constants.MaxBatchSize), nil))
+	}
+
+	results := make([]BatchItemResult, len(inputs))
+	events := make([]models.Event, 0, len(inputs))
+	positions := make([]int, 0, len(inputs)) // positions[i] is the batch index of events[i]
+
+	for i := range inputs {
+		results[i].Index = i
+		event, validationErrors, err := prepareEvent(ctx, &inputs[i])
+		switch {
+		case err != nil:
+			results[i].Status = constants.BatchStatusFailed
+			results[i].Error = err.Error()
+		case validationErrors.HasErrors():
+			results[i].Status = constants.BatchStatusInvalid
+			results[i].Errors = toHTTPValidationErrors(validationErrors)
+		default:
+			events = append(events, event)
+			positions = append(positions, i)
+		}
+	}
+
+	failed, err := internalUtils.InsertEvents(ctx, events)
+	if err != nil {
+		log.Printf("failed to insert event batch: %v", err)
+		return httpx.SendResponse(c, httpx.InternalServerError("Failed to create events", err))
+	}
+
+	created := 0
+	stored := make([]models.Event, 0, len(events))
+	for i, event := range events {
+		result := &results[positions[i]]
+		if writeErr, ok := failed[i]; ok {
+			result.Status = constants.BatchStatusFailed
+			result.Error = writeErr.Error()
+			continue
+		}
+		result.Status = constants.BatchStatusCreated
+		result.Id = event.Id.Hex()
+		stored = append(stored, event)
+		created++
+	}
+	recordRollups(ctx, stored...)
+
+	log.Printf("event batch processed: %d created, %d rejected", created, len(inputs)-created)
+
+	data := fiber.Map{
+		"created":  created,
+		"rejected": len(inputs) - created,
+		"results":  results,
+	}
+	if created == len(inputs) {
+		return httpx.SendResponse(c, httpx.Created("Events created successfully", data))
+	}
+	return httpx.SendResponse(c, httpx.OK("Events batch processed with errors", data))
+}
+
+// StreamLineReject describes a rejected line of an NDJSON ingestion stream
+type StreamLineReject struct {
+	Line   int                     `json:"line"`
+	Error  string                  `json:"error,omitempty"`
+	Errors []httpx.ValidationError `json:"validation_errors,omitempty"`
+}
+
+// StreamEvents ingests newline-delimited JSON (application/x-ndjson), optionally gzip-encoded
+// The body is read line by line, each line is validated as a CreateEventRequest and valid
+// events are inserted in bounded chunks, so the payload never has to be held in memory.
+// Responds with accepted/rejected counts and the line numbers of rejected lines.
+func StreamEvents(c *fiber.Ctx) error {
+	ctx := c.Context()
+
+	contentType := strings.TrimSpace(strings.SplitN(c.Get(fiber.HeaderContentType), ";", 2)[0])
+	if !strings.EqualFold(contentType, constants.ContentTypeNDJSON) {
+		return httpx.SendResponse(c, httpx.UnsupportedMediaType("Content-Type must be "+constants.ContentTypeNDJSON))
+	}
+
+	var body io.Reader = c.Context().RequestBodyStream()
+	if body == nil {
+		body = bytes.NewReader(c.Body())
+	}
+
+	switch strings.ToLower(c.Get(fiber.HeaderContentEncoding)) {
+	case "", "identity":
+	case "gzip":
+		gzipReader, err := gzip.NewReader(body)
+		if err != nil {
+			return httpx.SendResponse(c, httpx.BadRequest("Invalid gzip body", err))
+		}
+		defer gzipReader.Close()
+		body = gzipReader
+	default:
+		return httpx.SendResponse(c, httpx.UnsupportedMediaType("Content-Encoding must be gzip or identity"))
+	}
+
+	var (
+		accepted  int
+		rejected  int
+		rejects   []StreamLineReject
+		events    = make([]models.Event, 0, constants.NDJSONChunkSize)
+		lineNums  = make([]int, 0, constants.NDJSONChunkSize) // lineNums[i] is the line of events[i]
+		truncated bool
+	)
+
+	reject := func(entry StreamLineReject) {
+		rejected++
+		if len(rejects) < constants.MaxNDJSONRejectLines {
+			rejects = append(rejects, entry)
+		} else {
+			truncated = true
+		}
+	}
+
+	flush := func() error {
+		failed, err := internalUtils.InsertEvents(ctx, events)
+		if err != nil {
+			return err
+		}
+		stored := make([]models.Event, 0, len(events))
+		for i, event := range events {
+			if writeErr, ok := failed[i]; ok {
+				reject(StreamLineReject{Line: lineNums[i], Error: writeErr.Error()})
+				continue
+			}
+			stored = append(stored, event)
+			accepted++
+		}
+		recordRollups(ctx, stored...)
+		events = events[:0]
+		lineNums = lineNums[:0]
+		return nil
+	}
+
+	reader := bufio.NewReaderSize(body, constants.MaxNDJSONLineSize)
+	for lineNum := 1; ; lineNum++ {
+		line, err := readNDJSONLine(reader)
+		if err == errLineTooLong {
+			reject(StreamLineReject{Line: lineNum, Error: fmt.Sprintf("line exceeds %d bytes", constants.MaxNDJSONLineSize)})
+			continue
+		}
+		if err != nil && err != io.EOF {
+			log.Printf("failed to read NDJSON stream at line %d: %v", lineNum, err)
+			return httpx.SendResponse(c, httpx.BadRequest(fmt.Sprintf("Failed to read request body at line %d", lineNum), err))
+		}
+
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			var input requests.CreateEventRequest
+			if jsonErr := json.Unmarshal(trimmed, &input); jsonErr != nil {
+				reject(StreamLineReject{Line: lineNum, Error: jsonErr.Error()})
+			} else if event, validationErrors, prepareErr := prepareEvent(ctx, &input); prepareErr != nil {
+				reject(StreamLineReject{Line: lineNum, Error: prepareErr.Error()})
+			} else if validationErrors.HasErrors() {
+				reject(StreamLineReject{Line: lineNum, Errors: toHTTPValidationErrors(validationErrors)})
+			} else {
+				events = append(events, event)
+				lineNums = append(lineNums, lineNum)
+			}
+
+			if len(events) == constants.NDJSONChunkSize {
+				if flushErr := flush(); flushErr != nil {
+					log.Printf("failed to insert NDJSON chunk ending at line %d (%d accepted so far): %v", lineNum, accepted, flushErr)
+					return httpx.SendResponse(c, httpx.InternalServerError("Failed to create events", flushErr))
+				}
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if err := flush(); err != nil {
+		log.Printf("failed to insert final NDJSON chunk (%d accepted so far): %v", accepted, err)
+		return httpx.SendResponse(c, httpx.InternalServerError("Failed to create events", err))
+	}
+
+	log.Printf("event stream processed: %d accepted, %d rejected", accepted, rejected)
+
+	return httpx.SendResponse(c, httpx.OK("Events stream processed", fiber.Map{
+		"accepted":          accepted,
+		"rejected":          rejected,
+		"rejects":           rejects,
+		"rejects_truncated": truncated,
+	}))
+}
+
+var errLineTooLong = errors.New("line too long")
+
+// readNDJSONLine reads the next line without its terminator
+// Lines longer than the reader's buffer are discarded up to the next newline and reported as errLineTooLong
+func readNDJSONLine(reader *bufio.Reader) ([]byte, error) {
+	line, err := reader.ReadSlice('\n')
+	if err != bufio.ErrBufferFull {
+		return bytes.TrimSuffix(line, []byte("\n")), err
+	}
+
+	for err == bufio.ErrBufferFull {
+		_, err = reader.ReadSlice('\n')
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return nil, errLineTooLong
+}
+
+// prepareEvent validates a create request and builds the event to store, enforcing the schema of its name
+// Problems with the input are returned as validation errors, failures to load the schema as error
+func prepareEvent(ctx context.Context, input *requests.CreateEventRequest) (models.Event, validator.ValidationErrors, error) {
+	if validationErrors := validator.ValidateStruct(input); validationErrors.HasErrors() {
+		return models.Event{}, validationErrors, nil
+	}
+
+	event, validationErrors := internalUtils.NewEvent(*input, time.Now())
+	if validationErrors.HasErrors() {
+		return models.Event{}, validationErrors, nil
+	}
+
+	validationErrors, err := schemas.Enforce(ctx, &event)
+	if err != nil || validationErrors.HasErrors() {
+		return models.Event{}, validationErrors, err
+	}
+
+	return event, nil, nil
+}
+
+// toHTTPValidationErrors converts validator.ValidationErrors to []httpx.ValidationError
+func toHTTPValidationErrors(validationErrors validator.ValidationErrors) []httpx.ValidationError {
+	httpxErrors := make([]httpx.ValidationError, len(validationErrors))
+	for i, err := range validationErrors {
+		httpxErrors[i] = httpx.ValidationError{
+			Field:   err.Field,
+			Message: err.Message,
+		}
+	}
+	return httpxErrors
+}
+
 // GetEvents retrieves a paginated list of events with optional filtering and sorting
+// Events are paginated by page and limit unless pagination=cursor asks for keyset pagination:
+// its responses carry opaque next_cursor/prev_cursor tokens to pass back as the cursor parameter.
 // Supports query parameters:
-// - page: Page number (default: 1)
+// - pagination: 'offset' (default) or 'cursor'
+// - cursor: Token from a previous response's next_cursor or prev_cursor, implies pagination=cursor
+// - page: Page number of offset pagination (default: 1)
 // - limit: Items per page (default: 50)
-// - sortBy: Field to sort by (default: createdAt)
-// - sortOrder: Sort direction, 'asc' or 'desc' (default: asc)
-// - filters: Optional JSON string for complex MongoDB queries (e.g., {"status":"active","created_at":{"$gte":"2024-01-01"}})
+// - sortBy: Field to sort by (default: created_at), must match the cursor's
+// - sortOrder: Sort direction, 'asc' or 'desc' (default: asc), must match the cursor's
+// - name: Optional event name, or comma-separated list of names
+// - filters: Optional JSON filter expression (e.g., {"and":[{"field":"properties.status","op":"eq","value":"active"},{"field":"created_at","op":"gte","value":"2024-01-01"}]})
+// - includeTotal: Optional 'true'/'exact' to add total and total_pages, or 'estimated' for a
+// fast metadata-based count when no filter is set (falls back to an exact count otherwise)
 func GetEvents(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), constants.QueryTimeout)
 	defer cancel()
 
 	// Extract query parameters
+	pagination := c.Query(constants.ParamPagination, constants.PaginationOffset)
+	if pagination != constants.PaginationOffset && pagination != constants.PaginationCursor {
+		return httpx.SendResponse(c, httpx.BadRequest("pagination must be 'offset' or 'cursor'", nil))
+	}
+	cursorPagination := pagination == constants.PaginationCursor || c.Query(constants.ParamCursor) != ""
+	if cursorPagination && (c.Query(constants.ParamPage) != "" || c.Query(constants.ParamPagination) == constants.PaginationOffset) {
+		return httpx.SendResponse(c, httpx.BadRequest("cursor pagination cannot be combined with page or pagination=offset", nil))
+	}
 	page, err := strconv.Atoi(c.Query(constants.ParamPage, strconv.Itoa(constants.DefaultPage)))
 	if err != nil {
 		return httpx.SendResponse(c, httpx.BadRequest("Invalid page parameter", err))
@@ -88,6 +394,20 @@ func GetEvents(c *fiber.Ctx) error {
 	}
 	sortBy := c.Query(constants.ParamSortBy, constants.DefaultSortBy)
 	sortOrder := c.Query(constants.ParamSortOrder, constants.DefaultSortOrder)
+	includeTotal := c.Query(constants.ParamIncludeTotal)
+
+	var cursor *internalUtils.Cursor
+	if cursorStr := c.Query(constants.ParamCursor); cursorStr != "" {
+		cursor, err = internalUtils.DecodeCursor(cursorStr)
+		if err != nil {
+			return httpx.SendResponse(c, httpx.BadRequest("Invalid cursor parameter", err))
+		}
+		if (c.Query(constants.ParamSortBy) != "" && sortBy != cursor.SortBy) ||
+			(c.Query(constants.ParamSortOrder) != "" && sortOrder != cursor.SortOrder) {
+			return httpx.SendResponse(c, httpx.BadRequest("Sort parameters must match the cursor", nil))
+		}
+		sortBy, sortOrder = cursor.SortBy, cursor.SortOrder
+	}
 
 	// Validate parameters
 	if page < 1 {
@@ -102,33 +422,238 @@ func GetEvents(c *fiber.Ctx) error {
 	if sortOrder != "asc" && sortOrder != "desc" {
 		return httpx.SendResponse(c, httpx.BadRequest("Sort order must be 'asc' or 'desc'", nil))
 	}
+	if !isValidIncludeTotal(includeTotal) {
+		return httpx.SendResponse(c, httpx.BadRequest("includeTotal must be 'true', 'false', 'exact' or 'estimated'", nil))
+	}
 
 	// Parse JSON filters (optional)
 	var filters bson.M
 	if filterStr := c.Query("filters"); filterStr != "" {
 		var err error
-		filters, err = parseJSONFilters(filterStr)
+		filters, err = queryFilters.Parse(filterStr)
 		if err != nil {
 			return httpx.SendResponse(c, httpx.BadRequest("Invalid filters parameter", err))
 		}
 	}
+	filters = internalUtils.CombineFilters(filters, internalUtils.NameFilter(c.Query(constants.ParamName)))
 
-	// Query events
-	events, err := internalUtils.QueryEvents(ctx, filters, internalUtils.BuildSortOptions(sortBy, sortOrder), page, limit)
+	if !cursorPagination {
+		// Query events
+		events, hasMore, err := internalUtils.QueryEvents(ctx, filters, internalUtils.BuildSortOptions(sortBy, sortOrder), page, limit)
+		if err != nil {
+			return httpx.SendResponse(c, httpx.InternalServerError("Failed to fetch events", err))
+		}
+
+		data := fiber.Map{
+			constants.ParamPage:  page,
+			constants.ParamLimit: limit,
+			"events":             events,
+			"has_more":           hasMore,
+		}
+		if err := addTotals(ctx, data, filters, includeTotal, limit); err != nil {
+			return httpx.SendResponse(c, httpx.InternalServerError("Failed to count events", err))
+		}
+
+		return httpx.SendResponse(c, httpx.OK("Events retrieved successfully", data))
+	}
+
+	eventsPage, err := internalUtils.QueryEventsByCursor(ctx, filters, sortBy, sortOrder, cursor, limit)
 	if err != nil {
 		return httpx.SendResponse(c, httpx.InternalServerError("Failed to fetch events", err))
 	}
 
-	return httpx.SendResponse(c, httpx.OK("Events retrieved successfully", fiber.Map{
-		constants.ParamPage:  page,
+	data := fiber.Map{
 		constants.ParamLimit: limit,
-		"events":             events,
-	}))
+		"events":             eventsPage.Events,
+		"next_cursor":        eventsPage.NextCursor,
+		"prev_cursor":        eventsPage.PrevCursor,
+		"has_more":           eventsPage.NextCursor != "",
+	}
+	if err := addTotals(ctx, data, filters, includeTotal, limit); err != nil {
+		return httpx.SendResponse(c, httpx.InternalServerError("Failed to count events", err))
+	}
+
+	return httpx.SendResponse(c, httpx.OK("Events retrieved successfully", data))
+}
+
+// addTotals adds total and total_pages to a list response when includeTotal asks for them
+// A count that exceeds constants.CountTimeout is reported as total_timed_out instead of
+// failing the whole request, since the events themselves were retrieved fine
+func addTotals(ctx context.Context, data fiber.Map, filters bson.M, includeTotal string, limit int) error {
+	if includeTotal == "" || includeTotal == "false" {
+		return nil
+	}
+
+	total, estimated, err := internalUtils.CountEvents(ctx, filters, includeTotal == constants.IncludeTotalEstimated)
+	if errors.Is(err, internalUtils.ErrCountTimeout) {
+		data["total_timed_out"] = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	data["total"] = total
+	data["total_pages"] = (total + int64(limit) - 1) / int64(limit)
+	data["total_estimated"] = estimated
+	return nil
+}
+
+// isValidIncludeTotal checks if an includeTotal value is valid
+func isValidIncludeTotal(includeTotal string) bool {
+	validValues := []string{"", "true", "false", constants.IncludeTotalExact, constants.IncludeTotalEstimated}
+	for _, valid := range validValues {
+		if includeTotal == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// GetEvent retrieves a single event by its ID
+func GetEvent(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.QueryTimeout)
+	defer cancel()
+
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid event ID", err))
+	}
+
+	event, err := internalUtils.FindEventByID(ctx, id)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.InternalServerError("Failed to fetch event", err))
+	}
+	if event == nil || event.DeletedAt != nil {
+		return httpx.SendResponse(c, httpx.NotFound("Event not found"))
+	}
+
+	return httpx.SendResponse(c, httpx.OK("Event retrieved successfully", event))
+}
+
+// UpdateEvent corrects the properties of an event
+// The request's properties are applied as a JSON merge patch (RFC 7386): null removes a key,
+// objects are merged recursively and other values replace the existing ones.
+// The patched event is checked against the latest schema of its name again.
+func UpdateEvent(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.QueryTimeout)
+	defer cancel()
+
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid event ID", err))
+	}
+
+	var input requests.UpdateEventRequest
+	if err := c.BodyParser(&input); err != nil {
+		log.Printf("failed to parse request body: %v", err)
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid request body", err))
+	}
+	if validationErrors := validator.ValidateStruct(&input); validationErrors.HasErrors() {
+		log.Printf("validation failed for event update: %v", validationErrors)
+		response := httpx.UnprocessableEntityWithValidation("Validation failed", toHTTPValidationErrors(validationErrors))
+		return httpx.SendValidationResponse(c, response)
+	}
+
+	// Retry when the event changes between reading and writing it
+	for attempt := 0; attempt < constants.MaxUpdateAttempts; attempt++ {
+		event, err := internalUtils.FindEventByID(ctx, id)
+		if err != nil {
+			return httpx.SendResponse(c, httpx.InternalServerError("Failed to update event", err))
+		}
+		if event == nil || event.DeletedAt != nil {
+			return httpx.SendResponse(c, httpx.NotFound("Event not found"))
+		}
+
+		previous := *event
+		previousUpdatedAt := event.UpdatedAt
+		event.Properties = internalUtils.MergePatch(event.Properties, input.Properties)
+		event.UpdatedAt = time.Now()
+		event.SchemaVersion = 0
+		event.SchemaViolations = nil
+
+		validationErrors, err := schemas.Enforce(ctx, event)
+		if err != nil {
+			log.Printf("failed to check event against schema: %v", err)
+			return httpx.SendResponse(c, httpx.InternalServerError("Failed to update event", err))
+		}
+		if validationErrors.HasErrors() {
+			log.Printf("validation failed for event update: %v", validationErrors)
+			response := httpx.UnprocessableEntityWithValidation("Validation failed", toHTTPValidationErrors(validationErrors))
+			return httpx.SendValidationResponse(c, response)
+		}
+
+		updated, err := internalUtils.UpdateEvent(ctx, event, previousUpdatedAt)
+		if err != nil {
+			log.Printf("failed to update event in database: %v", err)
+			return httpx.SendResponse(c, httpx.InternalServerError("Failed to update event", err))
+		}
+		if updated {
+			log.Printf("event updated successfully with ID: %s", event.Id.Hex())
+			updateRollups(ctx, event.UpdatedAt, previous, *event)
+			return httpx.SendResponse(c, httpx.OK("Event updated successfully", event))
+		}
+	}
+
+	return httpx.SendResponse(c, httpx.Conflict("Event was modified concurrently, please retry", nil))
+}
+
+// DeleteEvent deletes an event
+// With EVENT_SOFT_DELETE enabled the event is kept with a deleted_at timestamp and
+// excluded from all queries and aggregations instead of being removed
+func DeleteEvent(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.QueryTimeout)
+	defer cancel()
+
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid event ID", err))
+	}
+
+	soft := config.GetEnvOrDefault("EVENT_SOFT_DELETE", "false") == "true"
+	changedAt := time.Now()
+	deleted, err := internalUtils.DeleteEvent(ctx, id, soft)
+	if err != nil {
+		log.Printf("failed to delete event from database: %v", err)
+		return httpx.SendResponse(c, httpx.InternalServerError("Failed to delete event", err))
+	}
+	if deleted == nil {
+		return httpx.SendResponse(c, httpx.NotFound("Event not found"))
+	}
+	// Soft deleted events were taken out of the rollups already
+	if deleted.DeletedAt == nil {
+		removeRollups(ctx, changedAt, *deleted)
+	}
+
+	log.Printf("event deleted successfully with ID: %s (soft: %t)", id.Hex(), soft)
+	return httpx.SendResponse(c, httpx.OK("Event deleted successfully", nil))
+}
+
+// recordRollups counts stored events into the rollups of their names
+// Failures are only logged, the events are stored already and the rollups written to are rebuilt.
+func recordRollups(ctx context.Context, events ...models.Event) {
+	if err := rollups.Record(ctx, events...); err != nil {
+		log.Printf("failed to record events in rollups: %v", err)
+	}
+}
+
+// updateRollups replaces a modified event in the rollups of its name
+func updateRollups(ctx context.Context, changedAt time.Time, previous, current models.Event) {
+	if err := rollups.Update(ctx, changedAt, previous, current); err != nil {
+		log.Printf("failed to update event in rollups: %v", err)
+	}
+}
+
+// removeRollups takes deleted events out of the rollups of their names
+func removeRollups(ctx context.Context, changedAt time.Time, events ...models.Event) {
+	if err := rollups.Remove(ctx, changedAt, events...); err != nil {
+		log.Printf("failed to remove events from rollups: %v", err)
+	}
 }
 
 // isValidSortField checks if a sort field is valid to prevent injection attacks
 func isValidSortField(field string) bool {
-	validFields := []string{"created_at", "updated_at", "id"}
+	validFields := []string{"created_at", "updated_at", "occurred_at", "id"}
 	for _, valid := range validFields {
 		if field == valid {
 			return true
@@ -137,9 +662,17 @@ func isValidSortField(field string) bool {
 	return false
 }
 
+// isValidTimeField checks if a time field can be used for time bucketing
+func isValidTimeField(field string) bool {
+	return field == constants.TimeFieldCreatedAt || field == constants.TimeFieldOccurredAt
+}
+
 // isValidAggregation checks if an aggregation type is valid
 func isValidAggregation(agg string) bool {
-	validAggregations := []string{"count", "sum", "avg"}
+	validAggregations := []string{
+		"count", "sum", "avg", "min", "max", "median", "p90", "p95", "p99", "stddev",
+		"count_distinct", "approx_count_distinct",
+	}
 	for _, valid := range validAggregations {
 		if agg == valid {
 			return true
@@ -148,108 +681,555 @@ func isValidAggregation(agg string) bool {
 	return false
 }
 
-// isValidTimeInterval checks if a time interval is valid
-func isValidTimeInterval(interval string) bool {
-	validIntervals := []string{"hour", "day", "week", "month"}
-	for _, valid := range validIntervals {
-		if interval == valid {
-			return true
+// parseGroupBy parses a comma-separated list of fields to group by
+func parseGroupBy(groupBy string) ([]string, error) {
+	fields := strings.Split(groupBy, ",")
+	if len(fields) > constants.MaxGroupByFields {
+		return nil, fmt.Errorf("at most %d groupBy fields are allowed", constants.MaxGroupByFields)
+	}
+
+	seen := make(map[string]bool, len(fields))
+	for i, field := range fields {
+		field = strings.TrimSpace(field)
+		if !queryFilters.IsValidGroupField(field) {
+			return nil, fmt.Errorf("invalid field %q, expected a top-level field other than id or a properties.* path", field)
 		}
+		if seen[field] {
+			return nil, fmt.Errorf("duplicate field %q", field)
+		}
+		seen[field] = true
+		fields[i] = field
 	}
-	return false
+
+	return fields, nil
+}
+
+// parseAggregations parses a comma-separated list of aggregations such as count,sum:properties.amount
+// Aggregations without a property read defaultField
+func parseAggregations(aggregates, defaultField string) ([]internalUtils.Aggregation, error) {
+	specs := strings.Split(aggregates, ",")
+	if len(specs) > constants.MaxAggregations {
+		return nil, fmt.Errorf("at most %d aggregations are allowed", constants.MaxAggregations)
+	}
+
+	aggregations := make([]internalUtils.Aggregation, 0, len(specs))
+	columns := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		op, field, hasField := strings.Cut(strings.TrimSpace(spec), ":")
+		if !hasField && op != constants.AggregationCount {
+			field = defaultField
+		}
+
+		if !isValidAggregation(op) {
+			return nil, fmt.Errorf("invalid aggregation type %q", op)
+		}
+		if err := validateAggregationField(op, field); err != nil {
+			return nil, err
+		}
+
+		aggregation := internalUtils.Aggregation{Op: op, Field: field}
+		if columns[aggregation.Column()] {
+			return nil, fmt.Errorf("duplicate aggregation %q", spec)
+		}
+		columns[aggregation.Column()] = true
+		aggregations = append(aggregations, aggregation)
+	}
+
+	return aggregations, nil
+}
+
+// parseWindowOps parses a comma-separated list of window operations such as moving_avg:7,cumulative
+func parseWindowOps(window string) ([]internalUtils.WindowOp, error) {
+	if window == "" {
+		return nil, nil
+	}
+
+	specs := strings.Split(window, ",")
+	if len(specs) > constants.MaxWindowOps {
+		return nil, fmt.Errorf("at most %d window operations are allowed", constants.MaxWindowOps)
+	}
+
+	windows := make([]internalUtils.WindowOp, 0, len(specs))
+	seen := make(map[internalUtils.WindowOp]bool, len(specs))
+	for _, spec := range specs {
+		op, size, hasSize := strings.Cut(strings.TrimSpace(spec), ":")
+
+		windowOp := internalUtils.WindowOp{Op: op}
+		switch op {
+		case constants.WindowMovingAvg:
+			n, err := strconv.Atoi(size)
+			if !hasSize || err != nil || n < 2 || n > constants.MaxMovingAvgSize {
+				return nil, fmt.Errorf("%s requires a number of buckets between 2 and %d, e.g. %s:7", op, constants.MaxMovingAvgSize, op)
+			}
+			windowOp.Size = n
+		case constants.WindowCumulative, constants.WindowRate:
+			if hasSize {
+				return nil, fmt.Errorf("%s takes no number of buckets", op)
+			}
+		default:
+			return nil, fmt.Errorf("invalid window operation %q", op)
+		}
+
+		if seen[windowOp] {
+			return nil, fmt.Errorf("duplicate window operation %q", spec)
+		}
+		seen[windowOp] = true
+		windows = append(windows, windowOp)
+	}
+
+	return windows, nil
+}
+
+// aggregationColumns returns the result column names of aggregations in request order,
+// followed by the columns of the window operations over each of them
+func aggregationColumns(aggregations []internalUtils.Aggregation, windows ...internalUtils.WindowOp) []string {
+	columns := make([]string, 0, len(aggregations)*(len(windows)+1))
+	for _, aggregation := range aggregations {
+		columns = append(columns, aggregation.Column())
+	}
+	for _, aggregation := range aggregations {
+		for _, window := range windows {
+			columns = append(columns, window.Column(aggregation))
+		}
+	}
+	return columns
+}
+
+// validateAggregationField checks that the aggregation has a safe property path to read when it needs one
+func validateAggregationField(aggregation, field string) error {
+	if field == "" {
+		if aggregation == constants.AggregationCount {
+			return nil
+		}
+		return fmt.Errorf("field parameter is required for %s aggregation", aggregation)
+	}
+	if !queryFilters.IsValidPropertyPath(field) {
+		return fmt.Errorf("invalid field %q, expected a properties.* path", field)
+	}
+	return nil
+}
+
+// loadTimezone loads an IANA time zone from Go's time zone database
+// Local is rejected since it means nothing to the database server
+func loadTimezone(name string) (*time.Location, error) {
+	if name == "" || name == "Local" {
+		return nil, fmt.Errorf("timezone must be an IANA time zone name such as Europe/Berlin")
+	}
+	return time.LoadLocation(name)
+}
+
+// parseTimeParam parses an RFC3339 timestamp or a date, taken as midnight in location
+// Returns the zero time for an empty value
+func parseTimeParam(value string, location *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, value, location); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q must be an RFC3339 timestamp or a date (2006-01-02)", value)
+}
+
+// parseComparison reads the compare, compareFrom and compareTo parameters of stats and time series
+// Returns the range [from, to) is compared with, zero times when no comparison is requested
+func parseComparison(c *fiber.Ctx, from, to time.Time, location *time.Location) (time.Time, time.Time, error) {
+	compare := c.Query(constants.ParamCompare)
+	compareFrom := c.Query(constants.ParamCompareFrom)
+	compareTo := c.Query(constants.ParamCompareTo)
+
+	if compare != constants.CompareCustom && (compareFrom != "" || compareTo != "") {
+		return time.Time{}, time.Time{}, fmt.Errorf("compareFrom and compareTo require compare=custom")
+	}
+
+	if compare == "" {
+		return time.Time{}, time.Time{}, nil
+	}
+	// Both ranges must be bounded for groups and buckets to be aligned with their previous ones
+	if from.IsZero() || to.IsZero() {
+		return time.Time{}, time.Time{}, fmt.Errorf("comparing periods requires from and to")
+	}
+
+	switch compare {
+	case constants.ComparePreviousPeriod, constants.ComparePreviousYear:
+		return internalUtils.PreviousRange(compare, from, to, location)
+	case constants.CompareCustom:
+		previousFrom, err := parseTimeParam(compareFrom, location)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		previousTo, err := parseTimeParam(compareTo, location)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		if previousFrom.IsZero() || previousTo.IsZero() {
+			return time.Time{}, time.Time{}, fmt.Errorf("compare=custom requires compareFrom and compareTo")
+		}
+		if !previousFrom.Before(previousTo) {
+			return time.Time{}, time.Time{}, fmt.Errorf("compareFrom must be before compareTo")
+		}
+		return previousFrom, previousTo, nil
+	default:
+		return time.Time{}, time.Time{}, fmt.Errorf("compare must be 'previous_period', 'previous_year' or 'custom'")
+	}
+}
+
+// isValidTimeInterval checks if a time interval is valid, see utils.ParseInterval for the accepted ones
+func isValidTimeInterval(interval string) bool {
+	_, err := internalUtils.ParseInterval(interval)
+	return err == nil
 }
 
 // GetStats aggregates event data based on grouping and aggregation criteria
 // Supports query parameters:
-// - groupBy: Field to group results by
-// - aggregates: Aggregation operation (count, sum, avg)
-// - filters: Optional JSON string for complex MongoDB queries
+// - groupBy: Field to group results by, or comma-separated fields to group by their combination
+// (e.g., properties.country,properties.platform)
+// - aggregates: Comma-separated aggregations, each an operation optionally followed by the property
+// it reads (e.g., count,sum:properties.amount,p95:properties.latency). Operations are count, sum,
+// avg, min, max, median, p90, p95, p99, stddev, count_distinct and approx_count_distinct.
+// Every aggregation is returned in a column of its own, see utils.Aggregation.Column
+// - field: Default property for aggregations that don't name one (e.g., properties.amount),
+// see utils.Aggregation for how non-numeric values are handled
+// - timeField: Timestamp from and to apply to, 'created_at' or 'occurred_at' (default: created_at)
+// - timezone: IANA time zone of dates given as from and to (default: UTC)
+// - from, to: Optional range of the events, RFC3339 timestamps or dates
+// - compare: Optional 'previous_period' (the range of the same length right before from),
+// 'previous_year' or 'custom' (compareFrom to compareTo) to aggregate a second range as well.
+// Groups are aligned by key and get previous, change and change_pct values per column.
+// Every comparison requires from and to
+// - name: Optional event name, or comma-separated list of names
+// - filters: Optional JSON filter expression, see the filters package
+// Requests for a single name without filters are answered from a rollup when one matches,
+// which the X-Events-Rollup response header names, see rollups.Find
 func GetStats(c *fiber.Ctx) error {
-	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(context.Background(), constants.QueryTimeout)
+	defer cancel()
 
 	// Extract query parameters
 	groupBy := c.Query(constants.ParamGroupBy, "")
 	aggregates := c.Query(constants.ParamAggregates, constants.DefaultAggregates)
+	field := c.Query(constants.ParamField)
+	timeField := c.Query(constants.ParamTimeField, constants.DefaultTimeField)
+	timezone := c.Query(constants.ParamTimezone, constants.DefaultTimezone)
 
 	// Validate parameters
 	if groupBy == "" {
 		return httpx.SendResponse(c, httpx.BadRequest("groupBy parameter is required", nil))
 	}
-	if !isValidAggregation(aggregates) {
-		return httpx.SendResponse(c, httpx.BadRequest("Invalid aggregation type", nil))
+	groupByFields, err := parseGroupBy(groupBy)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid groupBy parameter", err))
+	}
+	aggregations, err := parseAggregations(aggregates, field)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid aggregates parameter", err))
+	}
+	if !isValidTimeField(timeField) {
+		return httpx.SendResponse(c, httpx.BadRequest("Time field must be 'created_at' or 'occurred_at'", nil))
+	}
+	location, err := loadTimezone(timezone)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid timezone parameter", err))
+	}
+	from, err := parseTimeParam(c.Query(constants.ParamFrom), location)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid from parameter", err))
+	}
+	to, err := parseTimeParam(c.Query(constants.ParamTo), location)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid to parameter", err))
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return httpx.SendResponse(c, httpx.BadRequest("from must be before to", nil))
+	}
+	previousFrom, previousTo, err := parseComparison(c, from, to, location)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid compare parameters", err))
 	}
 
 	// Parse JSON filters (optional)
 	var filters bson.M
 	if filterStr := c.Query("filters"); filterStr != "" {
 		var err error
-		filters, err = parseJSONFilters(filterStr)
+		filters, err = queryFilters.Parse(filterStr)
 		if err != nil {
 			return httpx.SendResponse(c, httpx.BadRequest("Invalid filters parameter", err))
 		}
 	}
+	filters = internalUtils.CombineFilters(filters, internalUtils.NameFilter(c.Query(constants.ParamName)))
 
 	// Perform aggregation query
-	stats, err := internalUtils.AggregateStats(ctx, filters, groupBy, aggregates)
+	stats, err := aggregateStats(ctx, c, filters, groupByFields, aggregations, timeField, from, to)
 	if err != nil {
 		return httpx.SendResponse(c, httpx.InternalServerError("Failed to fetch stats", err))
 	}
 
-	return httpx.SendResponse(c, httpx.OK("Stats retrieved successfully", fiber.Map{
+	data := fiber.Map{
 		constants.ParamGroupBy:    groupBy,
 		constants.ParamAggregates: aggregates,
+		constants.ParamField:      field,
+		constants.ParamTimeField:  timeField,
+		constants.ParamTimezone:   timezone,
+		constants.ParamFrom:       c.Query(constants.ParamFrom),
+		constants.ParamTo:         c.Query(constants.ParamTo),
+		"columns":                 aggregationColumns(aggregations),
 		"stats":                   stats,
-	}))
+	}
+
+	if !previousFrom.IsZero() {
+		previous, err := aggregateStats(ctx, c, filters, groupByFields, aggregations, timeField, previousFrom, previousTo)
+		if err != nil {
+			return httpx.SendResponse(c, httpx.InternalServerError("Failed to fetch stats", err))
+		}
+		data["stats"] = internalUtils.CompareStats(stats, previous, aggregations)
+		addComparisonRange(c, data, previousFrom, previousTo, location)
+	}
+
+	return httpx.SendResponse(c, httpx.OK("Stats retrieved successfully", data))
+}
+
+// findRollup returns the rollup answering a stats or time series request, nil when the events have
+// to be scanned. Only requests for a single event name without filters can be answered from a
+// rollup, and lookup failures fall back to scanning the events.
+func findRollup(ctx context.Context, c *fiber.Ctx, query rollups.Query) *models.Rollup {
+	name := strings.TrimSpace(c.Query(constants.ParamName))
+	if c.Query(constants.ParamFilters) != "" || name == "" || strings.Contains(name, ",") {
+		return nil
+	}
+
+	query.EventName = name
+	rollup, err := rollups.Find(ctx, query)
+	if err != nil {
+		log.Printf("failed to look up rollups, scanning events instead: %v", err)
+		return nil
+	}
+	if rollup != nil {
+		c.Set(constants.HeaderRollup, rollup.Name)
+	}
+	return rollup
+}
+
+// aggregateStats aggregates the events in [from, to) from a matching rollup or by scanning them,
+// see utils.AggregateStats
+func aggregateStats(ctx context.Context, c *fiber.Ctx, filters bson.M, groupBy []string, aggregations []internalUtils.Aggregation, timeField string, from, to time.Time) ([]bson.M, error) {
+	rollup := findRollup(ctx, c, rollups.Query{
+		GroupBy:      groupBy,
+		Aggregations: aggregations,
+		TimeField:    timeField,
+		From:         from,
+		To:           to,
+	})
+	if rollup != nil {
+		return internalUtils.AggregateRollupStats(ctx, *rollup, groupBy, aggregations, from, to)
+	}
+	return internalUtils.AggregateStats(ctx, internalUtils.CombineFilters(filters, internalUtils.TimeRangeFilter(timeField, from, to)), groupBy, aggregations)
+}
+
+// aggregateTimeSeries aggregates a time series from a matching rollup or by scanning the events,
+// see utils.AggregateTimeSeries
+func aggregateTimeSeries(ctx context.Context, c *fiber.Ctx, filters bson.M, bucketing internalUtils.TimeBucketing, aggregations []internalUtils.Aggregation, windows []internalUtils.WindowOp) ([]bson.M, error) {
+	rollup := findRollup(ctx, c, rollups.Query{
+		Aggregations: aggregations,
+		TimeField:    bucketing.TimeField,
+		From:         bucketing.From,
+		To:           bucketing.To,
+		Bucketing:    &bucketing,
+	})
+	if rollup != nil {
+		return internalUtils.AggregateRollupTimeSeries(ctx, *rollup, bucketing, aggregations, windows)
+	}
+	return internalUtils.AggregateTimeSeries(ctx, filters, bucketing, aggregations, windows)
+}
+
+// addComparisonRange echoes the comparison mode and the range it ran over in a response
+func addComparisonRange(c *fiber.Ctx, data fiber.Map, previousFrom, previousTo time.Time, location *time.Location) {
+	data[constants.ParamCompare] = c.Query(constants.ParamCompare)
+	data[constants.ParamCompareFrom] = previousFrom.In(location).Format(time.RFC3339)
+	data[constants.ParamCompareTo] = previousTo.In(location).Format(time.RFC3339)
 }
 
 // GetTimeSeries generates time-based aggregations of event data
 // Supports query parameters:
-// - interval: Time grouping interval (hour, day, week, month)
-// - aggregates: Aggregation operation (count, sum, avg)
-// - filters: Optional JSON string for complex MongoDB queries
+// - interval: Time grouping interval: minute, 5m, 15m, hour, day, week (starting on Sunday),
+// isoweek (starting on Monday), month, quarter, year, or a custom number of minutes, hours
+// or days such as 30m, 6h or 7d
+// - aggregates: Comma-separated aggregations, see GetStats
+// - field: Default property for aggregations that don't name one (e.g., properties.amount)
+// - timeField: Timestamp to bucket on, 'created_at' or 'occurred_at' (default: created_at)
+// - timezone: IANA time zone buckets start and end in, e.g. Europe/Berlin (default: UTC).
+// Buckets are returned as RFC3339 timestamps of their start in that zone
+// - from: Optional inclusive start of the series on the time field, an RFC3339 timestamp or a date
+// (e.g., 2024-01-01, midnight in the requested timezone)
+// - to: Optional exclusive end of the series, same format as from
+// With both from and to the series is contiguous, buckets without events are filled with zero for
+// additive aggregations (count, sum, distinct counts) and null for the others. Otherwise only the
+// buckets with events are returned
+// - breakdown: Optional field to split the time series by, returning one series per value
+// - topN: Number of breakdown values with a series of their own, the rest are combined
+// into an 'other' series (default: 10, max: 50)
+// - window: Optional comma-separated window operations computed over the buckets of every
+// aggregation and returned as additional columns: moving_avg:N (average of the last N buckets),
+// cumulative (running total of count and sum aggregations) and rate (percent change from the
+// previous bucket), e.g. moving_avg:7,rate. See utils.WindowOp
+// - compare: Optional period to compare the series with, see GetStats. Buckets are aligned by
+// position and get previous_id, the start of the previous bucket, besides the previous values
+// and changes. Cannot be combined with breakdown
+// - name: Optional event name, or comma-separated list of names
+// - filters: Optional JSON filter expression, see the filters package
+// Requests without breakdown are answered from a rollup when one matches, as for GetStats
 func GetTimeSeries(c *fiber.Ctx) error {
-	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(context.Background(), constants.QueryTimeout)
+	defer cancel()
 
 	// Extract query parameters
 	aggregates := c.Query(constants.ParamAggregates, constants.DefaultAggregates)
 	interval := c.Query(constants.ParamInterval, constants.DefaultInterval)
+	timeField := c.Query(constants.ParamTimeField, constants.DefaultTimeField)
+	field := c.Query(constants.ParamField)
+	timezone := c.Query(constants.ParamTimezone, constants.DefaultTimezone)
+	breakdown := c.Query(constants.ParamBreakdown)
+	window := c.Query(constants.ParamWindow)
+	topN, err := strconv.Atoi(c.Query(constants.ParamTopN, strconv.Itoa(constants.DefaultTopN)))
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid topN parameter", err))
+	}
 
 	// Validate parameters
-	if !isValidAggregation(aggregates) {
-		return httpx.SendResponse(c, httpx.BadRequest("Invalid aggregation type", nil))
+	aggregations, err := parseAggregations(aggregates, field)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid aggregates parameter", err))
+	}
+	windows, err := parseWindowOps(window)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid window parameter", err))
+	}
+	if err := internalUtils.ValidateWindowOps(windows, aggregations); err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid window parameter", err))
+	}
+	if breakdown != "" && !queryFilters.IsValidGroupField(breakdown) {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid breakdown field, expected a top-level field other than id or a properties.* path", nil))
+	}
+	if topN < 1 || topN > constants.MaxTopN {
+		return httpx.SendResponse(c, httpx.BadRequest(fmt.Sprintf("topN must be between 1 and %d", constants.MaxTopN), nil))
 	}
 	if !isValidTimeInterval(interval) {
 		return httpx.SendResponse(c, httpx.BadRequest("Invalid time interval", nil))
 	}
+	if !isValidTimeField(timeField) {
+		return httpx.SendResponse(c, httpx.BadRequest("Time field must be 'created_at' or 'occurred_at'", nil))
+	}
+	location, err := loadTimezone(timezone)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid timezone parameter", err))
+	}
+	from, err := parseTimeParam(c.Query(constants.ParamFrom), location)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid from parameter", err))
+	}
+	to, err := parseTimeParam(c.Query(constants.ParamTo), location)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid to parameter", err))
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return httpx.SendResponse(c, httpx.BadRequest("from must be before to", nil))
+	}
+	previousFrom, previousTo, err := parseComparison(c, from, to, location)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid compare parameters", err))
+	}
+	if !previousFrom.IsZero() && breakdown != "" {
+		return httpx.SendResponse(c, httpx.BadRequest("compare cannot be combined with breakdown", nil))
+	}
+	if len(windows) > 0 && breakdown != "" {
+		return httpx.SendResponse(c, httpx.BadRequest("window cannot be combined with breakdown", nil))
+	}
+	// The buckets after the last one with events can only be windowed over a bounded series
+	if len(windows) > 0 && from.IsZero() && !to.IsZero() {
+		return httpx.SendResponse(c, httpx.BadRequest("window operations require from when to is set", nil))
+	}
 
 	// Parse JSON filters (optional)
 	var filters bson.M
 	if filterStr := c.Query("filters"); filterStr != "" {
 		var err error
-		filters, err = parseJSONFilters(filterStr)
+		filters, err = queryFilters.Parse(filterStr)
 		if err != nil {
 			return httpx.SendResponse(c, httpx.BadRequest("Invalid filters parameter", err))
 		}
 	}
+	filters = internalUtils.CombineFilters(filters, internalUtils.NameFilter(c.Query(constants.ParamName)))
+
+	parsedInterval, _ := internalUtils.ParseInterval(interval)
+	bucketing := internalUtils.TimeBucketing{
+		Interval:  parsedInterval,
+		TimeField: timeField,
+		Location:  location,
+		From:      from,
+		To:        to,
+	}
+
+	if breakdown != "" {
+		series, err := internalUtils.AggregateTimeSeriesBreakdown(ctx, filters, bucketing, aggregations, breakdown, topN)
+		if errors.Is(err, internalUtils.ErrTooManyBuckets) {
+			return httpx.SendResponse(c, httpx.BadRequest(err.Error(), nil))
+		}
+		if err != nil {
+			return httpx.SendResponse(c, httpx.InternalServerError("Failed to fetch time series", err))
+		}
+
+		return httpx.SendResponse(c, httpx.OK("Time series retrieved successfully", fiber.Map{
+			constants.ParamInterval:   interval,
+			constants.ParamAggregates: aggregates,
+			constants.ParamField:      field,
+			constants.ParamTimeField:  timeField,
+			constants.ParamTimezone:   timezone,
+			constants.ParamFrom:       c.Query(constants.ParamFrom),
+			constants.ParamTo:         c.Query(constants.ParamTo),
+			constants.ParamBreakdown:  breakdown,
+			constants.ParamTopN:       topN,
+			"columns":                 aggregationColumns(aggregations),
+			"series":                  series,
+		}))
+	}
 
 	// Perform time-series query
-	timeSeries, err := internalUtils.AggregateTimeSeries(ctx, filters, interval, aggregates)
+	timeSeries, err := aggregateTimeSeries(ctx, c, filters, bucketing, aggregations, windows)
+	if errors.Is(err, internalUtils.ErrTooManyBuckets) {
+		return httpx.SendResponse(c, httpx.BadRequest(err.Error(), nil))
+	}
 	if err != nil {
 		return httpx.SendResponse(c, httpx.InternalServerError("Failed to fetch time series", err))
 	}
 
-	return httpx.SendResponse(c, httpx.OK("Time series retrieved successfully", fiber.Map{
+	data := fiber.Map{
 		constants.ParamInterval:   interval,
 		constants.ParamAggregates: aggregates,
+		constants.ParamField:      field,
+		constants.ParamTimeField:  timeField,
+		constants.ParamTimezone:   timezone,
+		constants.ParamFrom:       c.Query(constants.ParamFrom),
+		constants.ParamTo:         c.Query(constants.ParamTo),
+		constants.ParamWindow:     window,
+		"columns":                 aggregationColumns(aggregations, windows...),
 		"timeSeries":              timeSeries,
-	}))
-}
+	}
 
-// parseJSONFilters parses a JSON string into MongoDB filters
-func parseJSONFilters(filterStr string) (bson.M, error) {
-	var filters bson.M
-	if err := json.Unmarshal([]byte(filterStr), &filters); err != nil {
-		return nil, fmt.Errorf("invalid filters JSON: %w", err)
+	if !previousFrom.IsZero() {
+		previousBucketing := bucketing
+		previousBucketing.From, previousBucketing.To = previousFrom, previousTo
+
+		previous, err := aggregateTimeSeries(ctx, c, filters, previousBucketing, aggregations, windows)
+		if errors.Is(err, internalUtils.ErrTooManyBuckets) {
+			return httpx.SendResponse(c, httpx.BadRequest(err.Error(), nil))
+		}
+		if err != nil {
+			return httpx.SendResponse(c, httpx.InternalServerError("Failed to fetch time series", err))
+		}
+		data["timeSeries"] = internalUtils.CompareTimeSeries(timeSeries, previous, aggregations)
+		addComparisonRange(c, data, previousFrom, previousTo, location)
 	}
-	return filters, nil
+
+	return httpx.SendResponse(c, httpx.OK("Time series retrieved successfully", data))
 }
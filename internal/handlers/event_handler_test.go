@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGroupBy(t *testing.T) {
+	tests := []struct {
+		name    string
+		groupBy string
+		want    []string
+		wantErr bool
+	}{
+		{"single field", "name", []string{"name"}, false},
+		{"several fields", "name, properties.country", []string{"name", "properties.country"}, false},
+		{"event id", "id", nil, true},
+		{"event id among others", "name,id", nil, true},
+		{"stored id", "_id", nil, true},
+		{"unknown top-level field", "deleted_at", nil, true},
+		{"duplicate field", "name,name", nil, true},
+		{"too many fields", "name,created_at,updated_at,occurred_at,properties.a,properties.b", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseGroupBy(tt.groupBy)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseGroupBy(%q) error = %v, wantErr %v", tt.groupBy, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseGroupBy(%q) = %v, want %v", tt.groupBy, got, tt.want)
+			}
+		})
+	}
+}
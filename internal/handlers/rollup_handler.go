@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"events-api/internal/constants"
+	queryFilters "events-api/internal/filters"
+	"events-api/internal/models"
+	"events-api/internal/requests"
+	"events-api/internal/rollups"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kerimovok/go-pkg-utils/httpx"
+	"github.com/kerimovok/go-pkg-utils/validator"
+)
+
+// CreateRollup declares a rollup, pre-aggregated counters of the events with a given name
+// Events are counted per hour or day and combination of dimension values, with the sums of the
+// metric fields. Stats and time series of that event name are answered from the rollup once the
+// events created before it are counted, see rollups.Find for the requests it can answer.
+func CreateRollup(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.QueryTimeout)
+	defer cancel()
+
+	var input requests.CreateRollupRequest
+	if err := c.BodyParser(&input); err != nil {
+		log.Printf("failed to parse request body: %v", err)
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid request body", err))
+	}
+
+	rollup, validationErrors := buildRollup(&input)
+	if validationErrors.HasErrors() {
+		log.Printf("validation failed for rollup creation: %v", validationErrors)
+		response := httpx.UnprocessableEntityWithValidation("Validation failed", toHTTPValidationErrors(validationErrors))
+		return httpx.SendValidationResponse(c, response)
+	}
+
+	created, err := rollups.CreateRollup(ctx, rollup)
+	if errors.Is(err, rollups.ErrRollupExists) {
+		return httpx.SendResponse(c, httpx.Conflict("Rollup already exists", err))
+	}
+	if err != nil {
+		log.Printf("failed to create rollup in database: %v", err)
+		return httpx.SendResponse(c, httpx.InternalServerError("Failed to create rollup", err))
+	}
+
+	log.Printf("rollup %q created for events %q", created.Name, created.EventName)
+	return httpx.SendResponse(c, httpx.Created("Rollup created successfully", created))
+}
+
+// GetRollups retrieves every declared rollup
+func GetRollups(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.QueryTimeout)
+	defer cancel()
+
+	list, err := rollups.ListRollups(ctx)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.InternalServerError("Failed to fetch rollups", err))
+	}
+
+	return httpx.SendResponse(c, httpx.OK("Rollups retrieved successfully", fiber.Map{
+		"rollups": list,
+	}))
+}
+
+// GetRollup retrieves a rollup by name
+func GetRollup(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.QueryTimeout)
+	defer cancel()
+
+	name, err := url.PathUnescape(c.Params("name"))
+	if err != nil || name == "" {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid rollup name", err))
+	}
+
+	rollup, err := rollups.FindRollup(ctx, name)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.InternalServerError("Failed to fetch rollup", err))
+	}
+	if rollup == nil {
+		return httpx.SendResponse(c, httpx.NotFound("Rollup not found"))
+	}
+
+	return httpx.SendResponse(c, httpx.OK("Rollup retrieved successfully", rollup))
+}
+
+// RebuildRollup counts every event into a rollup again
+// Stats and time series of its event name are computed from the events until the rebuild is done.
+func RebuildRollup(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.QueryTimeout)
+	defer cancel()
+
+	name, err := url.PathUnescape(c.Params("name"))
+	if err != nil || name == "" {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid rollup name", err))
+	}
+
+	rollup, err := rollups.RebuildRollup(ctx, name)
+	if err != nil {
+		log.Printf("failed to rebuild rollup: %v", err)
+		return httpx.SendResponse(c, httpx.InternalServerError("Failed to rebuild rollup", err))
+	}
+	if rollup == nil {
+		return httpx.SendResponse(c, httpx.NotFound("Rollup not found"))
+	}
+
+	log.Printf("rollup %q rebuild started", name)
+	return httpx.SendResponse(c, httpx.OK("Rollup rebuild started", rollup))
+}
+
+// DeleteRollup removes a rollup and its counters
+// Stats and time series of its event name are computed from the events again
+func DeleteRollup(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.QueryTimeout)
+	defer cancel()
+
+	name, err := url.PathUnescape(c.Params("name"))
+	if err != nil || name == "" {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid rollup name", err))
+	}
+
+	rollup, err := rollups.DeleteRollup(ctx, name)
+	if err != nil {
+		log.Printf("failed to delete rollup from database: %v", err)
+		return httpx.SendResponse(c, httpx.InternalServerError("Failed to delete rollup", err))
+	}
+	if rollup == nil {
+		return httpx.SendResponse(c, httpx.NotFound("Rollup not found"))
+	}
+
+	log.Printf("rollup %q deleted", name)
+	return httpx.SendResponse(c, httpx.OK("Rollup deleted successfully", rollup))
+}
+
+// buildRollup validates a rollup declaration and applies its defaults
+func buildRollup(input *requests.CreateRollupRequest) (models.Rollup, validator.ValidationErrors) {
+	validationErrors := validator.ValidateStruct(input)
+
+	if input.Granularity == "" {
+		input.Granularity = constants.DefaultRollupGranularity
+	}
+	if input.Granularity != constants.RollupGranularityHour && input.Granularity != constants.RollupGranularityDay {
+		validationErrors = append(validationErrors, validator.FieldError{
+			Field:   "granularity",
+			Message: "granularity must be 'hour' or 'day'",
+			Value:   input.Granularity,
+		})
+	}
+
+	if input.TimeField == "" {
+		input.TimeField = constants.DefaultTimeField
+	}
+	if !isValidTimeField(input.TimeField) {
+		validationErrors = append(validationErrors, validator.FieldError{
+			Field:   "time_field",
+			Message: "time field must be 'created_at' or 'occurred_at'",
+			Value:   input.TimeField,
+		})
+	}
+
+	if len(input.Dimensions) > constants.MaxRollupDimensions {
+		validationErrors = append(validationErrors, validator.FieldError{
+			Field:   "dimensions",
+			Message: fmt.Sprintf("at most %d dimensions are allowed", constants.MaxRollupDimensions),
+		})
+	}
+	dimensions := make([]string, 0, len(input.Dimensions))
+	for _, dimension := range input.Dimensions {
+		dimension = strings.TrimSpace(dimension)
+		switch {
+		case !queryFilters.IsValidPropertyPath(dimension):
+			validationErrors = append(validationErrors, validator.FieldError{
+				Field:   "dimensions",
+				Message: "dimensions must be properties.* paths",
+				Value:   dimension,
+			})
+		case slices.Contains(dimensions, dimension):
+			validationErrors = append(validationErrors, validator.FieldError{
+				Field:   "dimensions",
+				Message: "duplicate dimension",
+				Value:   dimension,
+			})
+		default:
+			dimensions = append(dimensions, dimension)
+		}
+	}
+
+	if len(input.Metrics) == 0 {
+		input.Metrics = []string{constants.AggregationCount}
+	}
+	if len(input.Metrics) > constants.MaxRollupMetrics {
+		validationErrors = append(validationErrors, validator.FieldError{
+			Field:   "metrics",
+			Message: fmt.Sprintf("at most %d metrics are allowed", constants.MaxRollupMetrics),
+		})
+	}
+	metrics := make([]models.RollupMetric, 0, len(input.Metrics))
+	for _, spec := range input.Metrics {
+		op, field, _ := strings.Cut(strings.TrimSpace(spec), ":")
+		metric := models.RollupMetric{Op: op, Field: field}
+		switch {
+		case op == constants.AggregationCount && field == "":
+		case (op == constants.AggregationSum || op == constants.AggregationAvg) && queryFilters.IsValidPropertyPath(field):
+		default:
+			validationErrors = append(validationErrors, validator.FieldError{
+				Field:   "metrics",
+				Message: "metrics must be count, sum:<field> or avg:<field> with a properties.* field",
+				Value:   spec,
+			})
+			continue
+		}
+		if slices.Contains(metrics, metric) {
+			validationErrors = append(validationErrors, validator.FieldError{
+				Field:   "metrics",
+				Message: "duplicate metric",
+				Value:   spec,
+			})
+			continue
+		}
+		metrics = append(metrics, metric)
+	}
+
+	return models.Rollup{
+		Name:        input.Name,
+		EventName:   input.EventName,
+		Dimensions:  dimensions,
+		Metrics:     metrics,
+		Granularity: input.Granularity,
+		TimeField:   input.TimeField,
+	}, validationErrors
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"events-api/internal/constants"
+	"events-api/internal/requests"
+	"events-api/internal/schemas"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kerimovok/go-pkg-utils/httpx"
+	"github.com/kerimovok/go-pkg-utils/validator"
+)
+
+// CreateSchema registers a new version of the JSON Schema for an event name
+// The new version immediately replaces the previous one for validation of incoming events
+func CreateSchema(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.QueryTimeout)
+	defer cancel()
+
+	var input requests.CreateSchemaRequest
+	if err := c.BodyParser(&input); err != nil {
+		log.Printf("failed to parse request body: %v", err)
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid request body", err))
+	}
+
+	validationErrors := validator.ValidateStruct(&input)
+	if input.Mode == "" {
+		input.Mode = constants.DefaultSchemaMode
+	}
+	if !isValidSchemaMode(input.Mode) {
+		validationErrors = append(validationErrors, validator.FieldError{
+			Field:   "mode",
+			Message: "mode must be 'reject', 'warn' or 'off'",
+			Value:   input.Mode,
+		})
+	}
+	if input.Schema != "" {
+		if _, err := schemas.Compile(input.Schema); err != nil {
+			validationErrors = append(validationErrors, validator.FieldError{
+				Field:   "schema",
+				Message: err.Error(),
+			})
+		}
+	}
+	if validationErrors.HasErrors() {
+		log.Printf("validation failed for schema creation: %v", validationErrors)
+		response := httpx.UnprocessableEntityWithValidation("Validation failed", toHTTPValidationErrors(validationErrors))
+		return httpx.SendValidationResponse(c, response)
+	}
+
+	schema, err := schemas.CreateSchema(ctx, input.Name, input.Mode, input.Schema)
+	if errors.Is(err, schemas.ErrVersionConflict) {
+		return httpx.SendResponse(c, httpx.Conflict("Schema version conflict", err))
+	}
+	if err != nil {
+		log.Printf("failed to create schema in database: %v", err)
+		return httpx.SendResponse(c, httpx.InternalServerError("Failed to create schema", err))
+	}
+
+	log.Printf("schema %q version %d created", schema.Name, schema.Version)
+	return httpx.SendResponse(c, httpx.Created("Schema created successfully", schema))
+}
+
+// GetSchemas retrieves the latest version of every registered schema
+func GetSchemas(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.QueryTimeout)
+	defer cancel()
+
+	list, err := schemas.ListSchemas(ctx)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.InternalServerError("Failed to fetch schemas", err))
+	}
+
+	return httpx.SendResponse(c, httpx.OK("Schemas retrieved successfully", fiber.Map{
+		"schemas": list,
+	}))
+}
+
+// GetSchema retrieves the schema for an event name
+// Supports query parameters:
+// - version: Optional schema version (default: latest)
+func GetSchema(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.QueryTimeout)
+	defer cancel()
+
+	name, version, err := schemaParams(c)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest(err.Error(), nil))
+	}
+
+	schema, err := schemas.FindSchema(ctx, name, version)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.InternalServerError("Failed to fetch schema", err))
+	}
+	if schema == nil {
+		return httpx.SendResponse(c, httpx.NotFound("Schema not found"))
+	}
+
+	return httpx.SendResponse(c, httpx.OK("Schema retrieved successfully", schema))
+}
+
+// GetSchemaVersions retrieves every version of the schema for an event name
+func GetSchemaVersions(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.QueryTimeout)
+	defer cancel()
+
+	name, _, err := schemaParams(c)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest(err.Error(), nil))
+	}
+
+	versions, err := schemas.ListSchemaVersions(ctx, name)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.InternalServerError("Failed to fetch schema versions", err))
+	}
+	if len(versions) == 0 {
+		return httpx.SendResponse(c, httpx.NotFound("Schema not found"))
+	}
+
+	return httpx.SendResponse(c, httpx.OK("Schema versions retrieved successfully", fiber.Map{
+		"name":     name,
+		"versions": versions,
+	}))
+}
+
+// UpdateSchema changes the enforcement mode of the latest schema version for an event name
+func UpdateSchema(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.QueryTimeout)
+	defer cancel()
+
+	name, _, err := schemaParams(c)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest(err.Error(), nil))
+	}
+
+	var input requests.UpdateSchemaRequest
+	if err := c.BodyParser(&input); err != nil {
+		log.Printf("failed to parse request body: %v", err)
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid request body", err))
+	}
+
+	validationErrors := validator.ValidateStruct(&input)
+	if input.Mode != "" && !isValidSchemaMode(input.Mode) {
+		validationErrors = append(validationErrors, validator.FieldError{
+			Field:   "mode",
+			Message: "mode must be 'reject', 'warn' or 'off'",
+			Value:   input.Mode,
+		})
+	}
+	if validationErrors.HasErrors() {
+		response := httpx.UnprocessableEntityWithValidation("Validation failed", toHTTPValidationErrors(validationErrors))
+		return httpx.SendValidationResponse(c, response)
+	}
+
+	schema, err := schemas.UpdateSchemaMode(ctx, name, input.Mode)
+	if err != nil {
+		log.Printf("failed to update schema in database: %v", err)
+		return httpx.SendResponse(c, httpx.InternalServerError("Failed to update schema", err))
+	}
+	if schema == nil {
+		return httpx.SendResponse(c, httpx.NotFound("Schema not found"))
+	}
+
+	log.Printf("schema %q version %d mode set to %s", schema.Name, schema.Version, schema.Mode)
+	return httpx.SendResponse(c, httpx.OK("Schema updated successfully", schema))
+}
+
+// DeleteSchema removes the schema for an event name
+// Supports query parameters:
+// - version: Optional schema version to delete (default: all versions)
+func DeleteSchema(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.QueryTimeout)
+	defer cancel()
+
+	name, version, err := schemaParams(c)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest(err.Error(), nil))
+	}
+
+	deleted, err := schemas.DeleteSchema(ctx, name, version)
+	if err != nil {
+		log.Printf("failed to delete schema from database: %v", err)
+		return httpx.SendResponse(c, httpx.InternalServerError("Failed to delete schema", err))
+	}
+	if deleted == 0 {
+		return httpx.SendResponse(c, httpx.NotFound("Schema not found"))
+	}
+
+	log.Printf("deleted %d version(s) of schema %q", deleted, name)
+	return httpx.SendResponse(c, httpx.OK("Schema deleted successfully", fiber.Map{
+		"name":    name,
+		"deleted": deleted,
+	}))
+}
+
+// schemaParams extracts the event name path parameter and the optional version query parameter
+func schemaParams(c *fiber.Ctx) (string, int, error) {
+	name, err := url.PathUnescape(c.Params("name"))
+	if err != nil || name == "" {
+		return "", 0, fmt.Errorf("Invalid schema name")
+	}
+
+	version := 0
+	if versionStr := c.Query(constants.ParamVersion); versionStr != "" {
+		version, err = strconv.Atoi(versionStr)
+		if err != nil || version < 1 {
+			return "", 0, fmt.Errorf("Version must be a positive number")
+		}
+	}
+
+	return name, version, nil
+}
+
+// isValidSchemaMode checks if a schema enforcement mode is valid
+func isValidSchemaMode(mode string) bool {
+	validModes := []string{constants.SchemaModeReject, constants.SchemaModeWarn, constants.SchemaModeOff}
+	for _, valid := range validModes {
+		if mode == valid {
+			return true
+		}
+	}
+	return false
+}
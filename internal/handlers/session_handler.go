@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"events-api/internal/analytics"
+	"events-api/internal/constants"
+	queryFilters "events-api/internal/filters"
+	internalUtils "events-api/internal/utils"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kerimovok/go-pkg-utils/httpx"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// GetSessions lists the sessions of the matching events, the most recent first
+// Query parameters:
+// - actor: Field identifying who performed the events (e.g. properties.user_id), required
+// - timeout: Inactivity starting a new session, e.g. 30m or 2h (default: 30m)
+// - page: Page number (default: 1)
+// - limit: Sessions per page (default: 50)
+// - timeField: Time field sessions are computed on (default: created_at)
+// - timezone: IANA time zone of dates given as from and to (default: UTC)
+// - from, to: Optional range of the events, RFC3339 timestamps or dates
+// - name: Optional event name, or comma-separated list of names
+// - filters: Optional JSON filter expression selecting the events taking part in sessions
+func GetSessions(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.AnalyticsTimeout)
+	defer cancel()
+
+	page, err := strconv.Atoi(c.Query(constants.ParamPage, strconv.Itoa(constants.DefaultPage)))
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid page parameter", err))
+	}
+	limit, err := strconv.Atoi(c.Query(constants.ParamLimit, strconv.Itoa(constants.DefaultLimit)))
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid limit parameter", err))
+	}
+	if page < 1 {
+		return httpx.SendResponse(c, httpx.BadRequest("Page must be a positive number", nil))
+	}
+	if limit < 1 || limit > 1000 {
+		return httpx.SendResponse(c, httpx.BadRequest("Limit must be between 1 and 1000", nil))
+	}
+
+	location, err := loadTimezone(c.Query(constants.ParamTimezone, constants.DefaultTimezone))
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid timezone parameter", err))
+	}
+	sessionization, err := parseSessionization(c, location)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest(err.Error(), nil))
+	}
+
+	sessions, hasMore, err := analytics.ListSessions(ctx, sessionization, page, limit)
+	if err != nil {
+		log.Printf("failed to list sessions: %v", err)
+		return httpx.SendResponse(c, httpx.InternalServerError("Failed to fetch sessions", err))
+	}
+
+	return httpx.SendResponse(c, httpx.OK("Sessions retrieved successfully", fiber.Map{
+		constants.ParamActor:   sessionization.Actor,
+		constants.ParamTimeout: c.Query(constants.ParamTimeout, constants.DefaultSessionTimeout),
+		constants.ParamPage:    page,
+		constants.ParamLimit:   limit,
+		"sessions":             sessions,
+		"has_more":             hasMore,
+	}))
+}
+
+// GetSessionStats summarizes the sessions of the matching events overall and per interval
+// Sessions count in the bucket they start in. Takes the parameters of GetSessions except page and
+// limit, plus:
+// - interval: Time interval of the buckets, as for time series (default: day)
+// - timezone: IANA time zone the buckets start and end in (default: UTC)
+func GetSessionStats(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.AnalyticsTimeout)
+	defer cancel()
+
+	interval := c.Query(constants.ParamInterval, constants.DefaultInterval)
+	timezone := c.Query(constants.ParamTimezone, constants.DefaultTimezone)
+
+	parsedInterval, err := internalUtils.ParseInterval(interval)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid time interval", err))
+	}
+	location, err := loadTimezone(timezone)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest("Invalid timezone parameter", err))
+	}
+	sessionization, err := parseSessionization(c, location)
+	if err != nil {
+		return httpx.SendResponse(c, httpx.BadRequest(err.Error(), nil))
+	}
+
+	total, buckets, err := analytics.ComputeSessionStats(ctx, sessionization, parsedInterval, location)
+	if errors.Is(err, internalUtils.ErrTooManyBuckets) {
+		return httpx.SendResponse(c, httpx.BadRequest(err.Error(), nil))
+	}
+	if err != nil {
+		log.Printf("failed to compute session stats: %v", err)
+		return httpx.SendResponse(c, httpx.InternalServerError("Failed to compute session stats", err))
+	}
+
+	return httpx.SendResponse(c, httpx.OK("Session stats retrieved successfully", fiber.Map{
+		constants.ParamActor:     sessionization.Actor,
+		constants.ParamTimeout:   c.Query(constants.ParamTimeout, constants.DefaultSessionTimeout),
+		constants.ParamInterval:  interval,
+		constants.ParamTimeField: sessionization.TimeField,
+		constants.ParamTimezone:  timezone,
+		constants.ParamFrom:      c.Query(constants.ParamFrom),
+		constants.ParamTo:        c.Query(constants.ParamTo),
+		"total":                  total,
+		"timeSeries":             buckets,
+	}))
+}
+
+// parseSessionization reads the actor, timeout, time range and event selection shared by the
+// session endpoints. Dates given as from and to are taken as midnight in location.
+func parseSessionization(c *fiber.Ctx, location *time.Location) (analytics.Sessionization, error) {
+	actor := c.Query(constants.ParamActor)
+	timeField := c.Query(constants.ParamTimeField, constants.DefaultTimeField)
+
+	if actor == "" {
+		return analytics.Sessionization{}, fmt.Errorf("actor parameter is required")
+	}
+	if !queryFilters.IsValidFieldPath(actor) {
+		return analytics.Sessionization{}, fmt.Errorf("invalid actor field, expected a top-level field or a properties.* path")
+	}
+	timeout, err := analytics.ParseSessionTimeout(c.Query(constants.ParamTimeout, constants.DefaultSessionTimeout))
+	if err != nil {
+		return analytics.Sessionization{}, err
+	}
+	if !isValidTimeField(timeField) {
+		return analytics.Sessionization{}, fmt.Errorf("time field must be 'created_at' or 'occurred_at'")
+	}
+	from, err := parseTimeParam(c.Query(constants.ParamFrom), location)
+	if err != nil {
+		return analytics.Sessionization{}, fmt.Errorf("invalid from parameter: %w", err)
+	}
+	to, err := parseTimeParam(c.Query(constants.ParamTo), location)
+	if err != nil {
+		return analytics.Sessionization{}, fmt.Errorf("invalid to parameter: %w", err)
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return analytics.Sessionization{}, fmt.Errorf("from must be before to")
+	}
+
+	// Parse JSON filters (optional)
+	var filters bson.M
+	if filterStr := c.Query(constants.ParamFilters); filterStr != "" {
+		filters, err = queryFilters.Parse(filterStr)
+		if err != nil {
+			return analytics.Sessionization{}, fmt.Errorf("invalid filters parameter: %w", err)
+		}
+	}
+	filters = internalUtils.CombineFilters(filters, internalUtils.NameFilter(c.Query(constants.ParamName)))
+
+	return analytics.Sessionization{
+		Actor:     actor,
+		Timeout:   timeout,
+		Filters:   filters,
+		TimeField: timeField,
+		From:      from,
+		To:        to,
+	}, nil
+}
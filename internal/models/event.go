@@ -7,8 +7,13 @@ import (
 )
 
 type Event struct {
-	Id         primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
-	Properties map[string]interface{} `bson:"properties" json:"properties"`
-	CreatedAt  time.Time              `bson:"created_at" json:"created_at"`
-	UpdatedAt  time.Time              `bson:"updated_at" json:"updated_at"`
+	Id               primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	Name             string                 `bson:"name,omitempty" json:"name,omitempty"`
+	Properties       map[string]interface{} `bson:"properties" json:"properties"`
+	SchemaVersion    int                    `bson:"schema_version,omitempty" json:"schema_version,omitempty"`       // Version of the schema the event was checked against
+	SchemaViolations []SchemaViolation      `bson:"schema_violations,omitempty" json:"schema_violations,omitempty"` // Set when the schema is in warn mode
+	OccurredAt       time.Time              `bson:"occurred_at" json:"occurred_at"`
+	CreatedAt        time.Time              `bson:"created_at" json:"created_at"`
+	UpdatedAt        time.Time              `bson:"updated_at" json:"updated_at"`
+	DeletedAt        *time.Time             `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"` // Set when the event was soft deleted
 }
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// IdempotencyKey records which event was created for a client supplied idempotency key
+// Documents expire through a TTL index on CreatedAt. ClaimedAt is when the request storing
+// EventId claimed the key, taking an abandoned claim over restarts both.
+type IdempotencyKey struct {
+	Key       string             `bson:"_id" json:"key"`
+	EventId   primitive.ObjectID `bson:"event_id" json:"event_id"`
+	ClaimedAt time.Time          `bson:"claimed_at" json:"claimed_at"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Rollup declares pre-aggregated counters maintained on ingest for the events with a given name
+// Events are counted per time bucket of the granularity and combination of dimension values,
+// along with the sum of every metric field, so stats and time series can be answered without
+// scanning the events.
+type Rollup struct {
+	Id          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name        string             `bson:"name" json:"name"`
+	EventName   string             `bson:"event_name" json:"event_name"`
+	Dimensions  []string           `bson:"dimensions" json:"dimensions"`
+	Metrics     []RollupMetric     `bson:"metrics" json:"metrics"`
+	Granularity string             `bson:"granularity" json:"granularity"`
+	TimeField   string             `bson:"time_field" json:"time_field"`
+	Status      string             `bson:"status" json:"status"`
+	Generation  primitive.ObjectID `bson:"generation" json:"generation"`   // documents of the current build, replaced by a rebuild
+	ActiveFrom  time.Time          `bson:"active_from" json:"active_from"` // events created since are counted on ingest, older ones by the backfill
+	Changes     int64              `bson:"changes" json:"changes"`         // changes to events counted by the backfill made while building
+	ReadyAt     *time.Time         `bson:"ready_at,omitempty" json:"ready_at,omitempty"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// RollupMetric is an aggregation a rollup can answer, count or the sum or average of a field
+type RollupMetric struct {
+	Op    string `bson:"op" json:"op"`
+	Field string `bson:"field,omitempty" json:"field,omitempty"`
+}
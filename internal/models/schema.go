@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Schema is a versioned JSON Schema for the properties of events with a given name
+type Schema struct {
+	Id         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name       string             `bson:"name" json:"name"`
+	Version    int                `bson:"version" json:"version"`
+	Mode       string             `bson:"mode" json:"mode"`
+	Definition RawJSON            `bson:"definition" json:"schema"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// SchemaViolation describes a property that does not satisfy the schema of its event
+type SchemaViolation struct {
+	Field   string `bson:"field" json:"field"`
+	Message string `bson:"message" json:"message"`
+}
+
+// RawJSON is a JSON document stored as a string
+// JSON Schema keywords such as $ref and $defs are not valid MongoDB field names everywhere,
+// so definitions are persisted verbatim and passed through untouched in API responses
+type RawJSON string
+
+// MarshalJSON writes the stored document as is
+func (r RawJSON) MarshalJSON() ([]byte, error) {
+	if r == "" {
+		return []byte("null"), nil
+	}
+	return []byte(r), nil
+}
+
+// UnmarshalJSON keeps the raw document
+func (r *RawJSON) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*r = ""
+		return nil
+	}
+	*r = RawJSON(data)
+	return nil
+}
@@ -3,8 +3,14 @@ package queue
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"events-api/internal/constants"
 	"events-api/internal/database"
 	"events-api/internal/models"
+	"events-api/internal/requests"
+	"events-api/internal/rollups"
+	"events-api/internal/schemas"
+	"events-api/internal/utils"
 	"fmt"
 	"log"
 	"strconv"
@@ -12,6 +18,7 @@ import (
 	"time"
 
 	"github.com/kerimovok/go-pkg-utils/config"
+	"github.com/kerimovok/go-pkg-utils/validator"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
@@ -24,6 +31,8 @@ type Consumer struct {
 type EventTask struct {
 	Properties map[string]interface{} `json:"properties"`
 	Type       string                 `json:"type"`
+	Timestamp  *requests.Timestamp    `json:"timestamp,omitempty"`
+	SentAt     *requests.Timestamp    `json:"sent_at,omitempty"`
 }
 
 func NewConsumer() (*Consumer, error) {
@@ -236,15 +245,44 @@ func (c *Consumer) processEventTask(msg amqp.Delivery) {
 
 	log.Printf("Processing event task type: %s (attempt %d/%d)", eventTask.Type, retryCount+1, maxRetries)
 
+	event, validationErrors := newTaskEvent(eventTask, getReceivedAt(msg))
+	if validationErrors.HasErrors() {
+		log.Printf("Invalid event task: %v", validationErrors)
+		// Retrying will not make the event valid, reject and send to DLQ
+		if err := msg.Reject(false); err != nil {
+			log.Printf("Failed to reject invalid message: %v", err)
+		}
+		return
+	}
+
+	idempotencyKey := getIdempotencyKey(msg)
+
 	// Process the event task
-	err := c.processEvent(eventTask)
+	err := c.processEvent(event, idempotencyKey)
+	var schemaErrors validator.ValidationErrors
+	if errors.As(err, &schemaErrors) {
+		log.Printf("Event task does not match its schema: %v", schemaErrors)
+		// Retrying will not make the event valid, reject and send to DLQ
+		if err := msg.Reject(false); err != nil {
+			log.Printf("Failed to reject invalid message: %v", err)
+		}
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to process event task (attempt %d/%d): %v", retryCount+1, maxRetries, err)
 
-		// Increment retry count and requeue with delay
+		// Increment retry count and requeue with delay, keeping the original headers
 		newHeaders := amqp.Table{}
-		if msg.Headers == nil {
-			msg.Headers = amqp.Table{}
+		for key, value := range msg.Headers {
+			newHeaders[key] = value
+		}
+		if idempotencyKey != "" {
+			// Retries are republished without the message id, so carry the key in a header
+			newHeaders[constants.AMQPHeaderIdempotencyKey] = idempotencyKey
+		}
+		if _, exists := newHeaders["x-received-at"]; !exists {
+			// Keep the first receive time so clock skew correction ignores the retry delay
+			newHeaders["x-received-at"] = time.Now().UnixMilli()
 		}
 		newHeaders["x-retry-count"] = retryCount + 1
 		newHeaders["x-last-error"] = err.Error()
@@ -267,26 +305,70 @@ func (c *Consumer) processEventTask(msg amqp.Delivery) {
 	log.Printf("Event processed successfully from queue: %s", eventTask.Type)
 }
 
-func (c *Consumer) processEvent(eventTask EventTask) error {
+func (c *Consumer) processEvent(event models.Event, idempotencyKey string) error {
 	// Create event in MongoDB
-	event := models.Event{
-		Properties: eventTask.Properties,
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
-	}
-
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	collection := database.DBClient.Database().Collection("events")
-	_, err := collection.InsertOne(ctx, event)
+	// Enforce the schema registered for the event name, violations in reject mode are returned as is
+	validationErrors, err := schemas.Enforce(ctx, &event)
 	if err != nil {
+		return fmt.Errorf("failed to enforce event schema: %v", err)
+	}
+	if validationErrors.HasErrors() {
+		return validationErrors
+	}
+
+	if idempotencyKey != "" {
+		existing, err := utils.ClaimIdempotencyKey(ctx, idempotencyKey, event.Id)
+		if err != nil {
+			return fmt.Errorf("failed to claim idempotency key: %v", err)
+		}
+		if existing != nil {
+			log.Printf("Skipping duplicate event task, idempotency key already used by event %s", existing.Id.Hex())
+			return nil
+		}
+	}
+
+	collection := database.DBClient.Database().Collection(constants.EventsCollection)
+	_, err = collection.InsertOne(ctx, event)
+	if err != nil {
+		if idempotencyKey != "" {
+			if releaseErr := utils.ReleaseIdempotencyKey(ctx, idempotencyKey); releaseErr != nil {
+				log.Printf("Failed to release idempotency key: %v", releaseErr)
+			}
+		}
 		return fmt.Errorf("failed to insert event: %v", err)
 	}
 
+	// The event is stored, so a failure to count it must not trigger a retry, the rollups written
+	// to are rebuilt instead
+	if err := rollups.Record(ctx, event); err != nil {
+		log.Printf("Failed to record event in rollups: %v", err)
+	}
+
 	return nil
 }
 
+// newTaskEvent builds the event of a task, the task type becomes the event name
+// The type is limited to the length REST requests validate names against.
+func newTaskEvent(eventTask EventTask, receivedAt time.Time) (models.Event, validator.ValidationErrors) {
+	if len(eventTask.Type) > constants.MaxEventNameLength {
+		return models.Event{}, validator.ValidationErrors{{
+			Field:   "type",
+			Message: fmt.Sprintf("length must be at most %d", constants.MaxEventNameLength),
+			Tag:     "max",
+		}}
+	}
+
+	return utils.NewEvent(requests.CreateEventRequest{
+		Name:       eventTask.Type,
+		Properties: eventTask.Properties,
+		Timestamp:  eventTask.Timestamp,
+		SentAt:     eventTask.SentAt,
+	}, receivedAt)
+}
+
 func (c *Consumer) Close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -357,6 +439,27 @@ func getRetryCount(msg amqp.Delivery) int {
 	return 0
 }
 
+// getReceivedAt returns when the event was first received, which differs from now for retried messages
+func getReceivedAt(msg amqp.Delivery) time.Time {
+	if msg.Headers != nil {
+		if receivedAt, ok := msg.Headers["x-received-at"].(int64); ok {
+			return time.UnixMilli(receivedAt)
+		}
+	}
+	return time.Now()
+}
+
+// getIdempotencyKey extracts the idempotency key of a delivery
+// The x-idempotency-key header takes precedence over the AMQP message id
+func getIdempotencyKey(msg amqp.Delivery) string {
+	if msg.Headers != nil {
+		if key, ok := msg.Headers[constants.AMQPHeaderIdempotencyKey].(string); ok && key != "" {
+			return key
+		}
+	}
+	return msg.MessageId
+}
+
 // calculateRetryDelay calculates delay with exponential backoff
 func calculateRetryDelay(retryCount int) time.Duration {
 	// Get configuration values
@@ -0,0 +1,33 @@
+package queue
+
+import (
+	"events-api/internal/constants"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewTaskEventNameLength(t *testing.T) {
+	tests := []struct {
+		name     string
+		taskType string
+		wantErr  bool
+	}{
+		{"short type", "signup", false},
+		{"longest type", strings.Repeat("a", constants.MaxEventNameLength), false},
+		{"too long type", strings.Repeat("a", constants.MaxEventNameLength+1), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := EventTask{Type: tt.taskType, Properties: map[string]interface{}{"plan": "pro"}}
+			event, validationErrors := newTaskEvent(task, time.Now())
+			if validationErrors.HasErrors() != tt.wantErr {
+				t.Fatalf("newTaskEvent() errors = %v, wantErr %v", validationErrors, tt.wantErr)
+			}
+			if !tt.wantErr && event.Name != tt.taskType {
+				t.Errorf("newTaskEvent() name = %q, want %q", event.Name, tt.taskType)
+			}
+		})
+	}
+}
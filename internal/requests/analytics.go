@@ -0,0 +1,41 @@
+package requests
+
+import "encoding/json"
+
+// EventMatchRequest selects events by name and optional filters
+type EventMatchRequest struct {
+	Name    string          `json:"name" validate:"required,max=255"`
+	Filters json.RawMessage `json:"filters,omitempty"` // Optional filter expression, see the filters package
+}
+
+type FunnelRequest struct {
+	Steps     []EventMatchRequest `json:"steps"`
+	Actor     string              `json:"actor" validate:"required"` // Property identifying who performed the events, e.g. properties.user_id
+	Window    string              `json:"window"`                    // Time allowed from the first to the last step, e.g. 30m, 24h or 7d (default: 7d)
+	TimeField string              `json:"time_field"`                // created_at or occurred_at (default: created_at)
+	From      *Timestamp          `json:"from,omitempty"`
+	To        *Timestamp          `json:"to,omitempty"`
+}
+
+type RetentionRequest struct {
+	StartEvent  EventMatchRequest `json:"start_event"`               // Event putting actors into the cohort of its period
+	ReturnEvent EventMatchRequest `json:"return_event"`              // Event counting actors as retained in a later period
+	Actor       string            `json:"actor" validate:"required"` // Property identifying who performed the events, e.g. properties.user_id
+	Interval    string            `json:"interval"`                  // day, week, isoweek or month (default: week)
+	Periods     int               `json:"periods"`                   // Number of periods after the cohort's own one (default: 8)
+	TimeField   string            `json:"time_field"`                // created_at or occurred_at (default: created_at)
+	Timezone    string            `json:"timezone"`                  // IANA time zone the periods start in (default: UTC)
+	From        *Timestamp        `json:"from,omitempty"`            // Range of the start events
+	To          *Timestamp        `json:"to,omitempty"`
+}
+
+type PathsRequest struct {
+	Anchor    EventMatchRequest `json:"anchor"`                    // Event the paths lead to or start from
+	Direction string            `json:"direction"`                 // before or after (default: after)
+	Depth     int               `json:"depth"`                     // Number of events in a path (default: 3)
+	Actor     string            `json:"actor" validate:"required"` // Property identifying who performed the events, e.g. properties.user_id
+	Limit     int               `json:"limit"`                     // Number of paths returned, the most frequent first (default: 20)
+	TimeField string            `json:"time_field"`                // created_at or occurred_at (default: created_at)
+	From      *Timestamp        `json:"from,omitempty"`
+	To        *Timestamp        `json:"to,omitempty"`
+}
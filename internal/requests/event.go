@@ -1,5 +1,12 @@
 package requests
 
 type CreateEventRequest struct {
+	Name       string                 `json:"name" validate:"max=255"`
 	Properties map[string]interface{} `json:"properties" validate:"required"`
+	Timestamp  *Timestamp             `json:"timestamp,omitempty"` // When the event happened on the client
+	SentAt     *Timestamp             `json:"sent_at,omitempty"`   // When the client sent the event, used to correct clock skew
+}
+
+type UpdateEventRequest struct {
+	Properties map[string]interface{} `json:"properties" validate:"required"` // JSON merge patch (RFC 7386) applied to the event properties
 }
@@ -0,0 +1,10 @@
+package requests
+
+type CreateRollupRequest struct {
+	Name        string   `json:"name" validate:"required,max=255"`
+	EventName   string   `json:"event_name" validate:"required,max=255"`
+	Dimensions  []string `json:"dimensions"`  // properties.* paths events are counted by
+	Metrics     []string `json:"metrics"`     // count, sum:<field> or avg:<field> (default: count)
+	Granularity string   `json:"granularity"` // hour or day (default: hour)
+	TimeField   string   `json:"time_field"`  // created_at or occurred_at (default: created_at)
+}
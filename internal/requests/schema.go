@@ -0,0 +1,13 @@
+package requests
+
+import "events-api/internal/models"
+
+type CreateSchemaRequest struct {
+	Name   string         `json:"name" validate:"required,max=255"`
+	Mode   string         `json:"mode"` // reject, warn or off (default: reject)
+	Schema models.RawJSON `json:"schema" validate:"required"`
+}
+
+type UpdateSchemaRequest struct {
+	Mode string `json:"mode" validate:"required"`
+}
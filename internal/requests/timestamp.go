@@ -0,0 +1,49 @@
+package requests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Timestamp is a point in time sent by a client, either as an RFC3339 string or as epoch milliseconds
+type Timestamp struct {
+	time.Time
+}
+
+// UnmarshalJSON accepts "2024-01-02T15:04:05Z" as well as 1704207845000
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || string(data) == "null" {
+		return nil
+	}
+
+	if data[0] == '"' {
+		var value string
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return fmt.Errorf("timestamp must be an RFC3339 string or epoch milliseconds: %w", err)
+		}
+		t.Time = parsed
+		return nil
+	}
+
+	var millis json.Number
+	if err := json.Unmarshal(data, &millis); err != nil {
+		return fmt.Errorf("timestamp must be an RFC3339 string or epoch milliseconds")
+	}
+	if ms, err := millis.Int64(); err == nil {
+		t.Time = time.UnixMilli(ms).UTC()
+		return nil
+	}
+	ms, err := millis.Float64()
+	if err != nil {
+		return fmt.Errorf("timestamp must be an RFC3339 string or epoch milliseconds")
+	}
+	t.Time = time.UnixMicro(int64(ms * 1000)).UTC()
+	return nil
+}
@@ -0,0 +1,191 @@
+package rollups
+
+import (
+	"context"
+	"errors"
+	"events-api/internal/constants"
+	"events-api/internal/database"
+	"events-api/internal/models"
+	"events-api/internal/utils"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ResumeBuilds restarts the builds of the rollups that aren't ready, which a restart interrupted,
+// and drops the documents of deleted rollups that instances kept counting into until their
+// cache expired
+func ResumeBuilds(ctx context.Context) error {
+	rollups, err := ListRollups(ctx)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]interface{}, len(rollups))
+	for i, rollup := range rollups {
+		ids[i] = rollup.Id
+	}
+	for _, granularity := range []string{constants.RollupGranularityHour, constants.RollupGranularityDay} {
+		if _, err := dataCollection(granularity).DeleteMany(ctx, bson.M{"r": bson.M{"$nin": ids}}); err != nil {
+			return err
+		}
+	}
+
+	for _, rollup := range rollups {
+		if rollup.Status != constants.RollupStatusReady {
+			go build(rollup)
+		}
+	}
+	return nil
+}
+
+// errBuildClaimed is returned when another instance is building a rollup
+var errBuildClaimed = errors.New("another instance is building the rollup")
+
+// build counts the events created before a rollup became active into its documents, then marks
+// it ready
+// It waits until events created before ActiveFrom are all stored and every instance counts into
+// the current generation of the rollup. A failed build is retried until the rollup is ready,
+// deleted or rebuilt, as is one another instance stopped working on.
+func build(rollup models.Rollup) {
+	if wait := time.Until(rollup.ActiveFrom.Add(constants.QueryTimeout)); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	builder := primitive.NewObjectID()
+	delay := constants.RollupBuildRetryDelay
+	for {
+		err := buildPasses(rollup, builder)
+		if err == nil {
+			return
+		}
+		log.Printf("failed to build rollup %q, retrying in %s: %v", rollup.Name, delay, err)
+		time.Sleep(delay)
+		delay = min(2*delay, constants.MaxRollupBuildRetryDelay)
+	}
+}
+
+// buildPasses runs the backfill of a rollup until no event it counts changed during a pass, then
+// marks the rollup ready
+// Changes to those events are recorded on the rollup while it builds, see recordChange.
+func buildPasses(rollup models.Rollup, builder primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.RollupBuildTimeout)
+	defer cancel()
+
+	current, err := findRollup(ctx, bson.M{"_id": rollup.Id, "generation": rollup.Generation})
+	if err != nil {
+		return err
+	}
+	if current != nil {
+		if current.Status == constants.RollupStatusReady {
+			return nil
+		}
+		claimed, err := claimBuild(ctx, rollup, builder)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			return errBuildClaimed
+		}
+
+		// Instances count into the current generation only by now
+		if _, err := dataCollection(rollup.Granularity).DeleteMany(ctx, bson.M{"r": rollup.Id, "g": bson.M{"$ne": rollup.Generation}}); err != nil {
+			return err
+		}
+		log.Printf("building rollup %q", rollup.Name)
+	}
+
+	for pass := 0; current != nil; pass++ {
+		if pass == constants.MaxRollupBackfillPasses {
+			return fmt.Errorf("events kept changing during %d passes", pass)
+		}
+		if err := backfill(ctx, rollup); err != nil {
+			return err
+		}
+
+		ready, err := markReady(ctx, rollup, builder, current.Changes)
+		if err != nil {
+			return err
+		}
+		if ready {
+			log.Printf("rollup %q is ready", rollup.Name)
+			return nil
+		}
+
+		// Events the backfill counts changed while it ran, unless the rollup is gone
+		if current, err = findRollup(ctx, bson.M{"_id": rollup.Id, "generation": rollup.Generation}); err != nil {
+			return err
+		}
+	}
+
+	// Deleted or rebuilt while building, drop what the build wrote
+	_, err = dataCollection(rollup.Granularity).DeleteMany(ctx, bson.M{"r": rollup.Id, "g": rollup.Generation})
+	return err
+}
+
+// backfill merges the counters of the events created before a rollup became active into bm
+// Documents the pass didn't write to are left from earlier passes, their events changed since,
+// so their bm is removed.
+func backfill(ctx context.Context, rollup models.Rollup) error {
+	pass := primitive.NewObjectID()
+
+	dimensions := bson.D{}
+	for _, field := range rollup.Dimensions {
+		dimensions = append(dimensions, bson.E{Key: utils.RollupDimensionKey(rollup, field), Value: bson.M{"$ifNull": bson.A{"$" + field, nil}}})
+	}
+
+	groupStage := bson.M{
+		"_id": bson.M{
+			"b": utils.RollupBucketing(rollup).TruncateExpr(utils.TimeFieldExpr(rollup.TimeField)),
+			"d": dimensions,
+		},
+		"count": bson.M{"$sum": 1},
+	}
+	for _, field := range metricFields(rollup) {
+		value := utils.NumericFieldExpr(field)
+		groupStage[utils.RollupSumCounter(field)] = bson.M{"$sum": value}
+		groupStage[utils.RollupValuesCounter(field)] = bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{value, nil}}, 0, 1}}}
+	}
+
+	pipeline := []bson.M{
+		{"$match": utils.CombineFilters(
+			bson.M{"name": rollup.EventName, constants.TimeFieldCreatedAt: bson.M{"$lt": rollup.ActiveFrom}},
+			utils.NotDeletedFilter(),
+		)},
+		{"$group": groupStage},
+		{"$replaceWith": bson.M{
+			"r":  rollup.Id,
+			"g":  rollup.Generation,
+			"b":  "$_id.b",
+			"d":  "$_id.d",
+			"bm": bson.M{"$unsetField": bson.M{"field": "_id", "input": "$$ROOT"}},
+			"bp": pass,
+		}},
+		{"$merge": bson.M{
+			"into":           utils.RollupCollection(rollup.Granularity),
+			"on":             bson.A{"r", "g", "b", "d"},
+			"whenMatched":    []bson.M{{"$set": bson.M{"bm": "$$new.bm", "bp": "$$new.bp"}}},
+			"whenNotMatched": "insert",
+		}},
+	}
+
+	// Bounded so it never outlives the claim of the build
+	opts := options.Aggregate().SetMaxTime(constants.RollupBuildTimeout)
+	cursor, err := database.DBClient.Database().Collection(constants.EventsCollection).Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return err
+	}
+	if err := cursor.Close(ctx); err != nil {
+		return err
+	}
+
+	_, err = dataCollection(rollup.Granularity).UpdateMany(ctx,
+		bson.M{"r": rollup.Id, "g": rollup.Generation, "bp": bson.M{"$exists": true, "$ne": pass}},
+		bson.M{"$unset": bson.M{"bm": "", "bp": ""}},
+	)
+	return err
+}
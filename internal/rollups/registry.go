@@ -0,0 +1,320 @@
+package rollups
+
+import (
+	"context"
+	"errors"
+	"events-api/internal/constants"
+	"events-api/internal/models"
+	"events-api/internal/utils"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	cacheMu  sync.RWMutex
+	cache    []models.Rollup
+	loadedAt time.Time
+)
+
+// Invalidate drops the cached rollups
+func Invalidate() {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	cache = nil
+	loadedAt = time.Time{}
+}
+
+// lookup returns the cached rollups, reloading them once the cache expired
+func lookup(ctx context.Context) ([]models.Rollup, error) {
+	cacheMu.RLock()
+	rollups, at := cache, loadedAt
+	cacheMu.RUnlock()
+	if !at.IsZero() && time.Since(at) < constants.RollupCacheTTL {
+		return rollups, nil
+	}
+
+	rollups, err := ListRollups(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rollups: %w", err)
+	}
+
+	cacheMu.Lock()
+	cache, loadedAt = rollups, time.Now()
+	cacheMu.Unlock()
+
+	return rollups, nil
+}
+
+// Record counts new events into the rollups of their names
+func Record(ctx context.Context, events ...models.Event) error {
+	return apply(ctx, time.Time{}, nil, events)
+}
+
+// Update replaces an event with its modified version in the rollups of its name
+// changedAt is a time before the modification was written.
+func Update(ctx context.Context, changedAt time.Time, previous, current models.Event) error {
+	return apply(ctx, changedAt, []models.Event{previous}, []models.Event{current})
+}
+
+// Remove takes deleted events out of the rollups of their names
+// changedAt is a time before the deletion was written.
+func Remove(ctx context.Context, changedAt time.Time, events ...models.Event) error {
+	return apply(ctx, changedAt, events, nil)
+}
+
+// change is an event added to or taken out of rollups
+type change struct {
+	event models.Event
+	sign  int
+}
+
+// apply takes removed events out of the counters of their rollup documents and adds added ones,
+// with one unordered bulk write per rollup collection
+// changedAt is zero for new events. A failed write leaves the counters of the rollups it wrote
+// to unknown, so they are rebuilt.
+func apply(ctx context.Context, changedAt time.Time, removed, added []models.Event) error {
+	rollups, err := lookup(ctx)
+	if err != nil || len(rollups) == 0 {
+		return err
+	}
+
+	changes := make([]change, 0, len(removed)+len(added))
+	for _, event := range removed {
+		changes = append(changes, change{event: event, sign: -1})
+	}
+	for _, event := range added {
+		changes = append(changes, change{event: event, sign: 1})
+	}
+
+	var errs []error
+	writes := map[string][]mongo.WriteModel{}
+	written := map[string][]models.Rollup{}
+	for _, rollup := range rollups {
+		var updates, built []mongo.WriteModel
+		for _, change := range changes {
+			switch {
+			case change.event.Name != rollup.EventName:
+			case !change.event.CreatedAt.Before(rollup.ActiveFrom):
+				updates = append(updates, increment(rollup, change.event, change.sign))
+			case !changedAt.IsZero():
+				// New events created before the rollup became active are counted by its build
+				built = append(built, increment(rollup, change.event, change.sign))
+			}
+		}
+
+		if len(built) > 0 {
+			counted, err := countsChange(ctx, rollup, changedAt)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to record change in rollup %q: %w", rollup.Name, err))
+				if err := markStale(rollup); err != nil {
+					log.Printf("failed to rebuild rollup %q: %v", rollup.Name, err)
+				}
+				continue
+			}
+			if counted {
+				updates = append(updates, built...)
+			}
+		}
+
+		if len(updates) > 0 {
+			writes[rollup.Granularity] = append(writes[rollup.Granularity], updates...)
+			written[rollup.Granularity] = append(written[rollup.Granularity], rollup)
+		}
+	}
+
+	for granularity, updates := range writes {
+		if _, err := dataCollection(granularity).BulkWrite(ctx, updates, options.BulkWrite().SetOrdered(false)); err != nil {
+			errs = append(errs, fmt.Errorf("failed to update %s rollups: %w", granularity, err))
+			for _, rollup := range written[granularity] {
+				if err := markStale(rollup); err != nil {
+					log.Printf("failed to rebuild rollup %q: %v", rollup.Name, err)
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// countsChange checks if the counters of a rollup take a change, made after changedAt, to an
+// event created before the rollup became active
+// Such events are counted by the build, which is told to count them again when one changes
+// while the rollup is building. Once it is ready the counters take changes made after its last
+// pass. A change the last pass may have read or not is settled by rebuilding the rollup.
+func countsChange(ctx context.Context, rollup models.Rollup, changedAt time.Time) (bool, error) {
+	if changedSinceReady(rollup, changedAt) {
+		return true, nil
+	}
+	recorded, err := recordChange(ctx, rollup)
+	if err != nil || recorded {
+		return false, err
+	}
+
+	// The cached rollup is outdated
+	current, err := findRollup(ctx, bson.M{"_id": rollup.Id, "generation": rollup.Generation})
+	if err != nil || current == nil {
+		// Deleted, or rebuilt from events read after the change
+		return false, err
+	}
+	if changedSinceReady(*current, changedAt) {
+		return true, nil
+	}
+	return false, markStale(*current)
+}
+
+// changedSinceReady checks if a rollup was ready before changedAt
+func changedSinceReady(rollup models.Rollup, changedAt time.Time) bool {
+	return rollup.Status == constants.RollupStatusReady && rollup.ReadyAt != nil && changedAt.After(*rollup.ReadyAt)
+}
+
+// increment returns the upsert adding sign times an event to the counters of its rollup document
+func increment(rollup models.Rollup, event models.Event, sign int) mongo.WriteModel {
+	timestamp := event.CreatedAt
+	if rollup.TimeField == constants.TimeFieldOccurredAt && !event.OccurredAt.IsZero() {
+		timestamp = event.OccurredAt
+	}
+
+	dimensions := bson.D{}
+	for _, field := range rollup.Dimensions {
+		dimensions = append(dimensions, bson.E{Key: utils.RollupDimensionKey(rollup, field), Value: propertyValue(event.Properties, field)})
+	}
+
+	counters := bson.M{"m.count": sign}
+	for _, field := range metricFields(rollup) {
+		if value, ok := utils.NumericValue(propertyValue(event.Properties, field)); ok {
+			counters["m."+utils.RollupSumCounter(field)] = float64(sign) * value
+			counters["m."+utils.RollupValuesCounter(field)] = sign
+		}
+	}
+
+	return mongo.NewUpdateOneModel().
+		SetFilter(bson.M{"r": rollup.Id, "g": rollup.Generation, "b": utils.RollupBucketing(rollup).Truncate(timestamp), "d": dimensions}).
+		SetUpdate(bson.M{"$inc": counters}).
+		SetUpsert(true)
+}
+
+// metricFields returns the fields a rollup keeps sums of, once each
+func metricFields(rollup models.Rollup) []string {
+	var fields []string
+	for _, metric := range rollup.Metrics {
+		if metric.Field != "" && !slices.Contains(fields, metric.Field) {
+			fields = append(fields, metric.Field)
+		}
+	}
+	return fields
+}
+
+// propertyValue returns the value at a properties.* path of an event, nil when it is missing
+func propertyValue(properties map[string]interface{}, path string) interface{} {
+	var value interface{} = properties
+	for _, key := range strings.Split(strings.TrimPrefix(path, "properties."), ".") {
+		switch object := value.(type) {
+		case map[string]interface{}:
+			value = object[key]
+		case bson.M:
+			value = object[key]
+		case bson.D:
+			value = nil
+			for _, element := range object {
+				if element.Key == key {
+					value = element.Value
+					break
+				}
+			}
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+// Query describes a stats or time series request that may be answered from a rollup
+type Query struct {
+	EventName    string
+	GroupBy      []string // stats only
+	Aggregations []utils.Aggregation
+	TimeField    string
+	From         time.Time            // inclusive start of the events, zero for unbounded
+	To           time.Time            // exclusive end of the events, zero for unbounded
+	Bucketing    *utils.TimeBucketing // time series only
+}
+
+// Find returns a ready rollup answering query exactly, nil when there is none
+// Daily rollups are preferred over hourly ones, they have fewer documents to read.
+func Find(ctx context.Context, query Query) (*models.Rollup, error) {
+	rollups, err := lookup(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var found *models.Rollup
+	for _, rollup := range rollups {
+		if !answers(rollup, query) {
+			continue
+		}
+		if found == nil || found.Granularity == constants.RollupGranularityHour {
+			found = &rollup
+		}
+	}
+	return found, nil
+}
+
+// answers checks if a rollup holds everything a query needs
+func answers(rollup models.Rollup, query Query) bool {
+	if rollup.Status != constants.RollupStatusReady || rollup.EventName != query.EventName || rollup.TimeField != query.TimeField {
+		return false
+	}
+
+	for _, field := range query.GroupBy {
+		if !slices.Contains(rollup.Dimensions, field) {
+			return false
+		}
+	}
+
+	fields := metricFields(rollup)
+	for _, aggregation := range query.Aggregations {
+		switch aggregation.Op {
+		case constants.AggregationCount:
+		case constants.AggregationSum, constants.AggregationAvg:
+			if !slices.Contains(fields, aggregation.Field) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	if !aligned(rollup, query.From) || !aligned(rollup, query.To) {
+		return false
+	}
+	return query.Bucketing == nil || coversBuckets(rollup, *query.Bucketing)
+}
+
+// aligned checks if t is the start of a bucket of a rollup, zero times are unbounded
+func aligned(rollup models.Rollup, t time.Time) bool {
+	return t.IsZero() || utils.RollupBucketing(rollup).Truncate(t).Equal(t)
+}
+
+// coversBuckets checks if every bucket of a time series is made of whole buckets of a rollup
+// Rollup buckets are UTC hours or days, so only UTC series line up with them.
+func coversBuckets(rollup models.Rollup, bucketing utils.TimeBucketing) bool {
+	if bucketing.Location != nil && bucketing.Location.String() != time.UTC.String() {
+		return false
+	}
+
+	switch bucketing.Interval.Unit {
+	case "hour":
+		return rollup.Granularity == constants.RollupGranularityHour
+	case "day", "week", "month", "quarter", "year":
+		return true
+	default:
+		return false
+	}
+}
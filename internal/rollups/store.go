@@ -0,0 +1,218 @@
+package rollups
+
+import (
+	"context"
+	"errors"
+	"events-api/internal/constants"
+	"events-api/internal/database"
+	"events-api/internal/models"
+	"events-api/internal/utils"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrRollupExists is returned when a rollup with the same name is already declared
+var ErrRollupExists = errors.New("a rollup with this name already exists")
+
+// CreateRollup declares a rollup and starts counting the existing events into it
+// Events created from ActiveFrom on are counted on ingest, once every instance has reloaded its
+// rollups. The older ones are counted by a build running in the background, and the rollup
+// answers queries once it is ready.
+func CreateRollup(ctx context.Context, rollup models.Rollup) (*models.Rollup, error) {
+	now := time.Now()
+	rollup.Status = constants.RollupStatusBuilding
+	rollup.Generation = primitive.NewObjectID()
+	rollup.ActiveFrom = now.Add(constants.RollupCacheTTL)
+	rollup.CreatedAt = now
+	rollup.UpdatedAt = now
+
+	result, err := collection().InsertOne(ctx, rollup)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrRollupExists
+		}
+		return nil, err
+	}
+
+	rollup.Id = result.InsertedID.(primitive.ObjectID)
+	Invalidate()
+	go build(rollup)
+	return &rollup, nil
+}
+
+// FindRollup retrieves a rollup by name
+// Returns nil without an error when it does not exist
+func FindRollup(ctx context.Context, name string) (*models.Rollup, error) {
+	return findRollup(ctx, bson.M{"name": name})
+}
+
+// findRollup retrieves the rollup matching filter, nil when there is none
+func findRollup(ctx context.Context, filter bson.M) (*models.Rollup, error) {
+	var rollup models.Rollup
+	if err := collection().FindOne(ctx, filter).Decode(&rollup); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &rollup, nil
+}
+
+// ListRollups retrieves every declared rollup, sorted by name
+func ListRollups(ctx context.Context) ([]models.Rollup, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: constants.SortAscending}})
+
+	cursor, err := collection().Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	rollups := []models.Rollup{}
+	if err = cursor.All(ctx, &rollups); err != nil {
+		return nil, err
+	}
+
+	return rollups, nil
+}
+
+// DeleteRollup removes a rollup and its documents
+// Returns nil without an error when it does not exist
+func DeleteRollup(ctx context.Context, name string) (*models.Rollup, error) {
+	var rollup models.Rollup
+	if err := collection().FindOneAndDelete(ctx, bson.M{"name": name}).Decode(&rollup); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	Invalidate()
+	if _, err := dataCollection(rollup.Granularity).DeleteMany(ctx, bson.M{"r": rollup.Id}); err != nil {
+		return nil, err
+	}
+
+	return &rollup, nil
+}
+
+// RebuildRollup counts every event into a rollup again, for instance after its counters were
+// written partially
+// The rollup gets a new generation of documents, counted like a new rollup, and answers queries
+// again once its build is done. Returns nil without an error when it does not exist.
+func RebuildRollup(ctx context.Context, name string) (*models.Rollup, error) {
+	return rebuild(ctx, bson.M{"name": name})
+}
+
+// markStale rebuilds a rollup whose counters missed changes, unless it was rebuilt since
+func markStale(rollup models.Rollup) error {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.QueryTimeout)
+	defer cancel()
+
+	rebuilt, err := rebuild(ctx, bson.M{"_id": rollup.Id, "generation": rollup.Generation})
+	if err != nil {
+		return err
+	}
+	if rebuilt != nil {
+		log.Printf("rebuilding rollup %q, its counters missed changes", rollup.Name)
+	}
+	return nil
+}
+
+// rebuild starts a new generation of the rollup matching filter and builds it in the background
+// Returns nil without an error when no rollup matches.
+func rebuild(ctx context.Context, filter bson.M) (*models.Rollup, error) {
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"status":      constants.RollupStatusBuilding,
+			"generation":  primitive.NewObjectID(),
+			"active_from": now.Add(constants.RollupCacheTTL),
+			"updated_at":  now,
+		},
+		"$unset": bson.M{"ready_at": "", "builder": "", "build_expires_at": ""},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var rollup models.Rollup
+	if err := collection().FindOneAndUpdate(ctx, filter, update, opts).Decode(&rollup); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	Invalidate()
+	go build(rollup)
+	return &rollup, nil
+}
+
+// recordChange tells the build of a rollup that an event it counts changed, so that it counts
+// the events again before the rollup is ready
+// Returns false when that generation of the rollup isn't building anymore.
+func recordChange(ctx context.Context, rollup models.Rollup) (bool, error) {
+	result, err := collection().UpdateOne(ctx,
+		bson.M{"_id": rollup.Id, "generation": rollup.Generation, "status": constants.RollupStatusBuilding},
+		bson.M{"$inc": bson.M{"changes": 1}},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount > 0, nil
+}
+
+// claimBuild makes builder the only one building the current generation of a rollup, until the
+// claim expires
+// Returns false when another build holds it, or the rollup isn't building anymore.
+func claimBuild(ctx context.Context, rollup models.Rollup, builder primitive.ObjectID) (bool, error) {
+	now := time.Now()
+	result, err := collection().UpdateOne(ctx,
+		bson.M{
+			"_id":        rollup.Id,
+			"generation": rollup.Generation,
+			"status":     constants.RollupStatusBuilding,
+			"$or": bson.A{
+				bson.M{"builder": builder},
+				bson.M{"build_expires_at": bson.M{"$not": bson.M{"$gt": now}}},
+			},
+		},
+		bson.M{"$set": bson.M{"builder": builder, "build_expires_at": now.Add(constants.RollupBuildTimeout + constants.QueryTimeout)}},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount > 0, nil
+}
+
+// markReady records that the build of a rollup is done, given no event it counts changed since
+// changes were read
+// Returns false when the rollup was deleted or rebuilt, or events changed in the meantime.
+func markReady(ctx context.Context, rollup models.Rollup, builder primitive.ObjectID, changes int64) (bool, error) {
+	now := time.Now()
+	result, err := collection().UpdateOne(ctx,
+		bson.M{"_id": rollup.Id, "generation": rollup.Generation, "builder": builder, "changes": changes},
+		bson.M{"$set": bson.M{"status": constants.RollupStatusReady, "ready_at": now, "updated_at": now}},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	Invalidate()
+	return result.MatchedCount > 0, nil
+}
+
+func collection() *mongo.Collection {
+	return database.DBClient.Database().Collection(constants.RollupsCollection)
+}
+
+// dataCollection returns the collection holding the documents of the rollups of a granularity
+func dataCollection(granularity string) *mongo.Collection {
+	return database.DBClient.Database().Collection(utils.RollupCollection(granularity))
+}
@@ -1,24 +1,114 @@
 package routes
 
 import (
+	"events-api/internal/cache"
+	"events-api/internal/constants"
 	"events-api/internal/handlers"
+	"fmt"
+	"io"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/monitor"
+	"github.com/kerimovok/go-pkg-utils/httpx"
 )
 
+// Route reading its body as a stream, exempt from the request body size limit
+const streamPath = "/api/v1/events/stream"
+
 func Setup(app *fiber.App) {
 	// API routes group
 	api := app.Group("/api")
 	v1 := api.Group("/v1")
 
+	// Bodies are streamed past the body limit (see main), every route but the NDJSON stream reads
+	// them in memory and needs them capped
+	v1.Use(limitBody(constants.MaxRequestBodySize, streamPath))
+
 	// Monitor route
 	app.Get("/metrics", monitor.New())
 
+	// Analytics responses are cached for a TTL per endpoint, 0 disables caching
+	statsCache := cache.New(cache.TTL("CACHE_TTL_STATS", constants.DefaultCacheTTLStats))
+	timeSeriesCache := cache.New(cache.TTL("CACHE_TTL_TIMESERIES", constants.DefaultCacheTTLTimeSeries))
+	analyticsCache := cache.New(cache.TTL("CACHE_TTL_ANALYTICS", constants.DefaultCacheTTLAnalytics))
+	sessionsCache := cache.New(cache.TTL("CACHE_TTL_SESSIONS", constants.DefaultCacheTTLSessions))
+
 	// Event routes
 	event := v1.Group("/events")
 	event.Post("/", handlers.CreateEvent)
+	event.Post("/batch", handlers.CreateEventsBatch)
+	event.Post("/stream", handlers.StreamEvents)
 	event.Get("/", handlers.GetEvents)
-	event.Get("/stats", handlers.GetStats)
-	event.Get("/timeseries", handlers.GetTimeSeries)
+	event.Get("/stats", statsCache, handlers.GetStats)
+	event.Get("/timeseries", timeSeriesCache, handlers.GetTimeSeries)
+	event.Get("/:id", handlers.GetEvent)
+	event.Patch("/:id", handlers.UpdateEvent)
+	event.Delete("/:id", handlers.DeleteEvent)
+
+	// Schema routes
+	schema := v1.Group("/schemas")
+	schema.Post("/", handlers.CreateSchema)
+	schema.Get("/", handlers.GetSchemas)
+	schema.Get("/:name", handlers.GetSchema)
+	schema.Get("/:name/versions", handlers.GetSchemaVersions)
+	schema.Patch("/:name", handlers.UpdateSchema)
+	schema.Delete("/:name", handlers.DeleteSchema)
+
+	// Rollup routes
+	rollup := v1.Group("/rollups")
+	rollup.Post("/", handlers.CreateRollup)
+	rollup.Get("/", handlers.GetRollups)
+	rollup.Get("/:name", handlers.GetRollup)
+	rollup.Post("/:name/rebuild", handlers.RebuildRollup)
+	rollup.Delete("/:name", handlers.DeleteRollup)
+
+	// Analytics routes
+	analytics := v1.Group("/analytics")
+	analytics.Post("/funnel", analyticsCache, handlers.GetFunnel)
+	analytics.Post("/retention", analyticsCache, handlers.GetRetention)
+	analytics.Post("/paths", analyticsCache, handlers.GetPaths)
+
+	// Session routes
+	session := v1.Group("/sessions")
+	session.Get("/", sessionsCache, handlers.GetSessions)
+	session.Get("/stats", sessionsCache, handlers.GetSessionStats)
+}
+
+// limitBody returns a middleware rejecting request bodies larger than limit bytes with a 413
+// With StreamRequestBody bodies past the server body limit aren't rejected by fasthttp but streamed,
+// and c.Body reads the whole stream. Streamed bodies are read here up to limit instead, so handlers
+// get them from c.Body as usual. Requests to the exempt paths keep the stream.
+func limitBody(limit int, exempt ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Routes match case-insensitively and with a trailing slash, like fiber does by default
+		for _, path := range exempt {
+			if strings.EqualFold(strings.TrimSuffix(c.Path(), "/"), path) {
+				return c.Next()
+			}
+		}
+
+		tooLarge := func() error {
+			// The rest of the body is left unread on the connection
+			c.Context().SetConnectionClose()
+			return httpx.SendResponse(c, httpx.PayloadTooLarge(fmt.Sprintf("Request body must not exceed %d bytes", limit)))
+		}
+		if c.Request().Header.ContentLength() > limit {
+			return tooLarge()
+		}
+
+		stream := c.Context().RequestBodyStream()
+		if stream == nil {
+			return c.Next()
+		}
+		body, err := io.ReadAll(io.LimitReader(stream, int64(limit)+1))
+		if err != nil {
+			return httpx.SendResponse(c, httpx.BadRequest("Failed to read request body", err))
+		}
+		if len(body) > limit {
+			return tooLarge()
+		}
+		c.Request().SetBody(body)
+		return c.Next()
+	}
 }
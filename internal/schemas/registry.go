@@ -0,0 +1,178 @@
+package schemas
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"events-api/internal/constants"
+	"events-api/internal/models"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kerimovok/go-pkg-utils/validator"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// schemaURL is the location compiled definitions are registered under
+const schemaURL = "mem:///schema.json"
+
+// cacheEntry holds the latest schema of an event name, schema is nil when none is registered
+type cacheEntry struct {
+	schema   *models.Schema
+	compiled *jsonschema.Schema
+	loadedAt time.Time
+}
+
+var (
+	cacheMu sync.RWMutex
+	cache   = map[string]cacheEntry{}
+)
+
+// Compile parses and compiles a JSON Schema definition
+// Remote and file references are not resolved, definitions must be self-contained
+func Compile(definition models.RawJSON) (*jsonschema.Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(strings.NewReader(string(definition)))
+	if err != nil {
+		return nil, fmt.Errorf("schema is not valid JSON: %w", err)
+	}
+
+	compiler := jsonschema.NewCompiler()
+	compiler.UseLoader(jsonschema.SchemeURLLoader{})
+	if err := compiler.AddResource(schemaURL, doc); err != nil {
+		return nil, err
+	}
+
+	return compiler.Compile(schemaURL)
+}
+
+// Invalidate drops the cached schema of an event name
+func Invalidate(name string) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	delete(cache, name)
+}
+
+// Enforce checks the properties of an event against the latest schema registered for its name
+// In reject mode violations are returned as validation errors and the event must not be stored,
+// in warn mode they are recorded on the event. Events without a name or schema are left untouched.
+func Enforce(ctx context.Context, event *models.Event) (validator.ValidationErrors, error) {
+	if event.Name == "" {
+		return nil, nil
+	}
+
+	entry, err := lookup(ctx, event.Name)
+	if err != nil {
+		return nil, err
+	}
+	if entry.schema == nil || entry.schema.Mode == constants.SchemaModeOff {
+		return nil, nil
+	}
+
+	event.SchemaVersion = entry.schema.Version
+	event.SchemaViolations = nil
+
+	violations, err := validateProperties(entry.compiled, event.Properties)
+	if err != nil {
+		return nil, err
+	}
+	if len(violations) == 0 {
+		return nil, nil
+	}
+
+	if entry.schema.Mode == constants.SchemaModeWarn {
+		event.SchemaViolations = violations
+		return nil, nil
+	}
+
+	validationErrors := make(validator.ValidationErrors, len(violations))
+	for i, violation := range violations {
+		validationErrors[i] = validator.FieldError{
+			Field:   violation.Field,
+			Message: violation.Message,
+			Tag:     "schema",
+		}
+	}
+	return validationErrors, nil
+}
+
+// lookup returns the cached latest schema of an event name, reloading it once the cache entry expired
+func lookup(ctx context.Context, name string) (cacheEntry, error) {
+	cacheMu.RLock()
+	entry, ok := cache[name]
+	cacheMu.RUnlock()
+	if ok && time.Since(entry.loadedAt) < constants.SchemaCacheTTL {
+		return entry, nil
+	}
+
+	schema, err := FindSchema(ctx, name, 0)
+	if err != nil {
+		return cacheEntry{}, fmt.Errorf("failed to load schema for %q: %w", name, err)
+	}
+
+	entry = cacheEntry{schema: schema, loadedAt: time.Now()}
+	if schema != nil {
+		if entry.compiled, err = Compile(schema.Definition); err != nil {
+			return cacheEntry{}, fmt.Errorf("failed to compile schema %q version %d: %w", name, schema.Version, err)
+		}
+	}
+
+	cacheMu.Lock()
+	cache[name] = entry
+	cacheMu.Unlock()
+
+	return entry, nil
+}
+
+// validateProperties validates properties against a compiled schema and flattens the violations
+func validateProperties(compiled *jsonschema.Schema, properties map[string]interface{}) ([]models.SchemaViolation, error) {
+	// Round-trip through JSON so that values decoded from BSON validate like request payloads
+	data, err := json.Marshal(properties)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode properties: %w", err)
+	}
+	instance, err := jsonschema.UnmarshalJSON(strings.NewReader(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode properties: %w", err)
+	}
+
+	err = compiled.Validate(instance)
+	if err == nil {
+		return nil, nil
+	}
+
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		return nil, err
+	}
+
+	var violations []models.SchemaViolation
+	for _, unit := range validationErr.BasicOutput().Errors {
+		if unit.Error == nil {
+			continue
+		}
+		violations = append(violations, models.SchemaViolation{
+			Field:   propertyPath(unit.InstanceLocation),
+			Message: unit.Error.String(),
+		})
+	}
+	if len(violations) == 0 {
+		violations = append(violations, models.SchemaViolation{Field: "properties", Message: validationErr.Error()})
+	}
+
+	return violations, nil
+}
+
+// propertyPath converts a JSON pointer within the properties into a dotted field path
+func propertyPath(pointer string) string {
+	path := "properties"
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		if token == "" {
+			continue
+		}
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		path += "." + token
+	}
+	return path
+}
@@ -0,0 +1,154 @@
+package schemas
+
+import (
+	"context"
+	"errors"
+	"events-api/internal/constants"
+	"events-api/internal/database"
+	"events-api/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrVersionConflict is returned when another version of the schema was created concurrently
+var ErrVersionConflict = errors.New("schema version was created concurrently, retry the request")
+
+// CreateSchema stores definition as the next version of the schema for an event name
+func CreateSchema(ctx context.Context, name, mode string, definition models.RawJSON) (*models.Schema, error) {
+	latest, err := FindSchema(ctx, name, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	schema := models.Schema{
+		Name:       name,
+		Version:    1,
+		Mode:       mode,
+		Definition: definition,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if latest != nil {
+		schema.Version = latest.Version + 1
+	}
+
+	result, err := collection().InsertOne(ctx, schema)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrVersionConflict
+		}
+		return nil, err
+	}
+
+	schema.Id = result.InsertedID.(primitive.ObjectID)
+	Invalidate(name)
+	return &schema, nil
+}
+
+// FindSchema retrieves a version of the schema for an event name, or the latest one when version is 0
+// Returns nil without an error when it does not exist
+func FindSchema(ctx context.Context, name string, version int) (*models.Schema, error) {
+	filter := bson.M{"name": name}
+	opts := options.FindOne().SetSort(bson.D{{Key: "version", Value: constants.SortDescending}})
+	if version > 0 {
+		filter["version"] = version
+	}
+
+	var schema models.Schema
+	if err := collection().FindOne(ctx, filter, opts).Decode(&schema); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &schema, nil
+}
+
+// ListSchemas retrieves the latest version of every registered schema
+func ListSchemas(ctx context.Context) ([]models.Schema, error) {
+	pipeline := []bson.M{
+		{"$sort": bson.D{{Key: "name", Value: 1}, {Key: "version", Value: -1}}},
+		{"$group": bson.M{"_id": "$name", "latest": bson.M{"$first": "$$ROOT"}}},
+		{"$replaceRoot": bson.M{"newRoot": "$latest"}},
+		{"$sort": bson.M{"name": 1}},
+	}
+
+	cursor, err := collection().Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	schemas := []models.Schema{}
+	if err = cursor.All(ctx, &schemas); err != nil {
+		return nil, err
+	}
+
+	return schemas, nil
+}
+
+// ListSchemaVersions retrieves every version of the schema for an event name, newest first
+func ListSchemaVersions(ctx context.Context, name string) ([]models.Schema, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "version", Value: constants.SortDescending}})
+
+	cursor, err := collection().Find(ctx, bson.M{"name": name}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	schemas := []models.Schema{}
+	if err = cursor.All(ctx, &schemas); err != nil {
+		return nil, err
+	}
+
+	return schemas, nil
+}
+
+// UpdateSchemaMode changes the enforcement mode of the latest version of the schema for an event name
+// Returns nil without an error when no schema is registered
+func UpdateSchemaMode(ctx context.Context, name, mode string) (*models.Schema, error) {
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "version", Value: constants.SortDescending}}).
+		SetReturnDocument(options.After)
+
+	var schema models.Schema
+	err := collection().FindOneAndUpdate(ctx, bson.M{"name": name}, bson.M{
+		"$set": bson.M{"mode": mode, "updated_at": time.Now()},
+	}, opts).Decode(&schema)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	Invalidate(name)
+	return &schema, nil
+}
+
+// DeleteSchema removes a version of the schema for an event name, or all versions when version is 0
+func DeleteSchema(ctx context.Context, name string, version int) (int64, error) {
+	filter := bson.M{"name": name}
+	if version > 0 {
+		filter["version"] = version
+	}
+
+	result, err := collection().DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	Invalidate(name)
+	return result.DeletedCount, nil
+}
+
+func collection() *mongo.Collection {
+	return database.DBClient.Database().Collection(constants.SchemasCollection)
+}
@@ -0,0 +1,345 @@
+package utils
+
+import (
+	"context"
+	"encoding/binary"
+	"events-api/internal/constants"
+	"events-api/internal/database"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Aggregation is an aggregation operation applied to the events of a group or time bucket
+// Field is the property path the operation reads (e.g. properties.amount); count ignores it.
+//
+// Numeric operations read values with NumericFieldExpr: numbers are used as they are, strings
+// holding a number (e.g. "12.5") are converted, and anything else (missing, null, booleans, dates,
+// objects, arrays and other strings) is skipped, so it neither adds to a sum nor counts towards
+// an average. Distinct counts compare the raw values and skip missing and null ones.
+type Aggregation struct {
+	Op    string
+	Field string
+}
+
+// Column returns the name of the result column holding the aggregation, e.g. count or sum_amount
+// The properties prefix is dropped and dots become underscores
+func (a Aggregation) Column() string {
+	if a.Field == "" {
+		return a.Op
+	}
+	field := strings.TrimPrefix(a.Field, "properties.")
+	return a.Op + "_" + strings.ReplaceAll(field, ".", "_")
+}
+
+// additive checks if the aggregation of a group without events is zero rather than undefined
+func (a Aggregation) additive() bool {
+	switch a.Op {
+	case constants.AggregationCount, constants.AggregationSum,
+		constants.AggregationCountDistinct, constants.AggregationApproxCountDistinct:
+		return true
+	default:
+		return false
+	}
+}
+
+// Quantiles computed by the percentile operations
+var percentileOps = map[string]float64{
+	constants.AggregationMedian: 0.5,
+	constants.AggregationP90:    0.9,
+	constants.AggregationP95:    0.95,
+	constants.AggregationP99:    0.99,
+}
+
+// $percentile and $median were added in MongoDB 7.0, older servers get the values
+// pushed into an array and the percentile is computed here
+const (
+	percentileMajorVersion = 7
+	percentileMinorVersion = 0
+)
+
+// accumulator returns the $group accumulator computing the aggregation
+// nativePercentiles selects $percentile over collecting the values for finalize
+func (a Aggregation) accumulator(nativePercentiles bool) bson.M {
+	value := NumericFieldExpr(a.Field)
+
+	if quantile, ok := percentileOps[a.Op]; ok {
+		if nativePercentiles {
+			return bson.M{"$percentile": bson.M{"input": value, "p": bson.A{quantile}, "method": "approximate"}}
+		}
+		return bson.M{"$push": value}
+	}
+
+	switch a.Op {
+	case constants.AggregationSum:
+		return bson.M{"$sum": value}
+	case constants.AggregationAvg:
+		return bson.M{"$avg": value}
+	case constants.AggregationMin:
+		return bson.M{"$min": value}
+	case constants.AggregationMax:
+		return bson.M{"$max": value}
+	case constants.AggregationStdDev:
+		return bson.M{"$stdDevPop": value}
+	case constants.AggregationCountDistinct:
+		return bson.M{"$addToSet": "$" + a.Field}
+	case constants.AggregationApproxCountDistinct:
+		return bson.M{"$sum": 0} // Filled in from the sketches of approxDistinct
+	default:
+		return bson.M{"$sum": 1} // Default to count
+	}
+}
+
+// finalize turns the accumulated value of a group into the aggregation result
+func (a Aggregation) finalize(value interface{}, nativePercentiles bool) interface{} {
+	if quantile, ok := percentileOps[a.Op]; ok {
+		values, _ := value.(bson.A)
+		if nativePercentiles {
+			if len(values) == 0 {
+				return nil
+			}
+			return values[0]
+		}
+		if value, ok := Percentile(numbers(values), quantile); ok {
+			return value
+		}
+		return nil
+	}
+
+	if a.Op == constants.AggregationCountDistinct {
+		values, _ := value.(bson.A)
+		count := 0
+		for _, v := range values {
+			if v != nil {
+				count++
+			}
+		}
+		return count
+	}
+
+	return value
+}
+
+// NumericFieldExpr returns an expression reading field as a number, or null when it is not numeric
+func NumericFieldExpr(field string) bson.M {
+	path := "$" + field
+	return bson.M{"$switch": bson.M{
+		"branches": bson.A{
+			bson.M{"case": bson.M{"$isNumber": path}, "then": path},
+			bson.M{
+				"case": bson.M{"$eq": bson.A{bson.M{"$type": path}, "string"}},
+				"then": bson.M{"$convert": bson.M{
+					"input":   bson.M{"$trim": bson.M{"input": path}},
+					"to":      "double",
+					"onError": nil,
+				}},
+			},
+		},
+		"default": nil,
+	}}
+}
+
+// NumericValue reads a property value as a number like NumericFieldExpr does
+func NumericValue(value interface{}) (float64, bool) {
+	if text, ok := value.(string); ok {
+		number, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+		return number, err == nil && !math.IsNaN(number)
+	}
+	return toFloat64(value)
+}
+
+// aggregateGroups runs aggregations over the events matching filters, grouped by groupKey
+// All aggregations are computed by a single $group stage. Every result holds the group key as _id
+// and one column per aggregation (see Aggregation.Column), sorted by _id. A single aggregation is
+// also returned as value, the column name used before several aggregations were supported.
+// stages run after the groups are sorted, e.g. to add window operations over time buckets.
+func aggregateGroups(ctx context.Context, filters bson.M, groupKey interface{}, aggregations []Aggregation, stages ...bson.M) ([]bson.M, error) {
+	nativePercentiles := database.SupportsVersion(ctx, percentileMajorVersion, percentileMinorVersion)
+
+	groupStage := bson.M{"_id": groupKey}
+	for _, aggregation := range aggregations {
+		groupStage[aggregation.Column()] = aggregation.accumulator(nativePercentiles)
+	}
+
+	pipeline := []bson.M{
+		// Match stage for filters, soft deleted events are never aggregated
+		{"$match": CombineFilters(filters, NotDeletedFilter())},
+		{"$group": groupStage},
+		{"$sort": bson.M{"_id": 1}},
+	}
+	pipeline = append(pipeline, stages...)
+
+	// Collecting values for percentiles and distinct counts can exceed the in-memory limit
+	opts := options.Aggregate().SetAllowDiskUse(true).SetMaxTime(constants.QueryTimeout)
+
+	collection := database.DBClient.Database().Collection(constants.EventsCollection)
+	cursor, err := collection.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []bson.Raw
+	if err = cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	// Approximate distinct counts need a pass of their own over the matching events
+	sketches := make(map[string]map[string]*hyperLogLog)
+	for _, aggregation := range aggregations {
+		if aggregation.Op != constants.AggregationApproxCountDistinct {
+			continue
+		}
+		sketches[aggregation.Column()], err = approxDistinct(ctx, filters, groupKey, aggregation.Field)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]bson.M, len(groups))
+	for i, group := range groups {
+		var result bson.M
+		if err := bson.Unmarshal(group, &result); err != nil {
+			return nil, err
+		}
+
+		for _, aggregation := range aggregations {
+			column := aggregation.Column()
+			if columnSketches, ok := sketches[column]; ok {
+				var estimate uint64
+				if sketch, ok := columnSketches[rawKey(group.Lookup("_id"))]; ok {
+					estimate = sketch.Count()
+				}
+				result[column] = estimate
+			} else {
+				result[column] = aggregation.finalize(result[column], nativePercentiles)
+			}
+		}
+		if len(aggregations) == 1 {
+			result["value"] = result[aggregations[0].Column()]
+		}
+
+		results[i] = result
+	}
+
+	return results, nil
+}
+
+// approxDistinct estimates the number of distinct values of field per group with HyperLogLog sketches
+// The values are streamed from the server instead of being collected into one document per group,
+// so memory stays bounded no matter how many distinct values a group has
+func approxDistinct(ctx context.Context, filters bson.M, groupKey interface{}, field string) (map[string]*hyperLogLog, error) {
+	pipeline := []bson.M{
+		{"$match": CombineFilters(filters, NotDeletedFilter(), bson.M{field: bson.M{"$ne": nil}})},
+		// $group keys missing values as null while $project leaves them out, the keys must match
+		{"$project": bson.M{"_id": 0, "g": bson.M{"$ifNull": bson.A{groupKey, nil}}, "v": "$" + field}},
+	}
+
+	collection := database.DBClient.Database().Collection(constants.EventsCollection)
+	cursor, err := collection.Aggregate(ctx, pipeline, options.Aggregate().SetMaxTime(constants.QueryTimeout))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sketches := make(map[string]*hyperLogLog)
+	for cursor.Next(ctx) {
+		key := rawKey(cursor.Current.Lookup("g"))
+		sketch, ok := sketches[key]
+		if !ok {
+			sketch = newHyperLogLog()
+			sketches[key] = sketch
+		}
+		sketch.Add(hashValue(cursor.Current.Lookup("v")))
+	}
+
+	return sketches, cursor.Err()
+}
+
+// rawKey returns a map key identifying a group key the way $group compares them
+// $group merges numbers of different types holding the same value into one group and keeps the
+// type of one of them, so numbers are keyed by their value like hashValue does, inside documents
+// and arrays as well. Other values are keyed by their type and encoding.
+func rawKey(value bson.RawValue) string {
+	return string(appendRawKey(nil, value))
+}
+
+// appendRawKey appends the key of a value to key, see rawKey
+func appendRawKey(key []byte, value bson.RawValue) []byte {
+	var number float64
+	switch value.Type {
+	case bsontype.Int32:
+		number = float64(value.Int32())
+	case bsontype.Int64:
+		number = float64(value.Int64())
+	case bsontype.Double:
+		number = value.Double()
+	case bsontype.EmbeddedDocument, bsontype.Array:
+		elements, err := bson.Raw(value.Value).Elements()
+		if err != nil {
+			return append(append(key, byte(value.Type)), value.Value...)
+		}
+		key = binary.AppendUvarint(append(key, byte(value.Type)), uint64(len(elements)))
+		for _, element := range elements {
+			if value.Type == bsontype.EmbeddedDocument {
+				key = append(append(key, element.Key()...), 0)
+			}
+			key = appendRawKey(key, element.Value())
+		}
+		return key
+	default:
+		return append(append(key, byte(value.Type)), value.Value...)
+	}
+
+	return binary.LittleEndian.AppendUint64(append(key, byte(bsontype.Double)), math.Float64bits(number))
+}
+
+// numbers returns the numeric values of a list, skipping everything else
+func numbers(values bson.A) []float64 {
+	result := make([]float64, 0, len(values))
+	for _, value := range values {
+		if number, ok := toFloat64(value); ok {
+			result = append(result, number)
+		}
+	}
+	return result
+}
+
+// toFloat64 converts a numeric BSON value to a float64
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, !math.IsNaN(v)
+	case primitive.Decimal128:
+		number, err := strconv.ParseFloat(v.String(), 64)
+		return number, err == nil && !math.IsNaN(number)
+	default:
+		return 0, false
+	}
+}
+
+// Percentile returns the nearest-rank quantile of values, false when there are none
+// It picks an actual value like MongoDB's approximate method does rather than interpolating.
+// values is sorted in place.
+func Percentile(values []float64, quantile float64) (float64, bool) {
+	if len(values) == 0 {
+		return 0, false
+	}
+
+	sort.Float64s(values)
+	rank := int(math.Ceil(quantile*float64(len(values)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return values[rank], true
+}
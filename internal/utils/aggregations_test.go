@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRawKey(t *testing.T) {
+	raw := func(t *testing.T, value interface{}) bson.RawValue {
+		kind, data, err := bson.MarshalValue(value)
+		if err != nil {
+			t.Fatalf("marshal %v: %v", value, err)
+		}
+		return bson.RawValue{Type: kind, Value: data}
+	}
+
+	tests := []struct {
+		name string
+		a, b interface{}
+		same bool
+	}{
+		{"int32 and int64", int32(5), int64(5), true},
+		{"int64 and double", int64(5), 5.0, true},
+		{"different numbers", int32(5), 5.5, false},
+		{"number and string", int64(5), "5", false},
+		{"string and null", "", primitive.Null{}, false},
+		{"equal strings", "US", "US", true},
+		{"numbers inside documents", bson.D{{Key: "d0", Value: "US"}, {Key: "d1", Value: int32(5)}}, bson.D{{Key: "d0", Value: "US"}, {Key: "d1", Value: 5.0}}, true},
+		{"different documents", bson.D{{Key: "d0", Value: "US"}, {Key: "d1", Value: int32(5)}}, bson.D{{Key: "d0", Value: "US"}, {Key: "d1", Value: int32(6)}}, false},
+		{"different field names", bson.D{{Key: "d0", Value: int32(5)}}, bson.D{{Key: "d1", Value: int32(5)}}, false},
+		{"numbers inside arrays", bson.A{int32(1), int64(2)}, bson.A{1.0, 2.0}, true},
+		{"array and document", bson.A{int32(1)}, bson.D{{Key: "0", Value: int32(1)}}, false},
+		{"longer array", bson.A{int32(1)}, bson.A{int32(1), int32(1)}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := rawKey(raw(t, tt.a)) == rawKey(raw(t, tt.b)); same != tt.same {
+				t.Errorf("rawKey(%v) == rawKey(%v) is %v, want %v", tt.a, tt.b, same, tt.same)
+			}
+		})
+	}
+}
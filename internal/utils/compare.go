@@ -0,0 +1,185 @@
+package utils
+
+import (
+	"events-api/internal/constants"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// PreviousRange returns the range a period-over-period comparison of [from, to) runs over
+// previous_period is the range of the same length right before from, previous_year the same
+// calendar range a year earlier. Both ends must be set.
+//
+// Lengths are counted in location: ranges of whole months go back as many months, ranges of
+// whole days as many days, whatever their length in hours, and any other range its duration.
+func PreviousRange(mode string, from, to time.Time, location *time.Location) (time.Time, time.Time, error) {
+	if from.IsZero() || to.IsZero() {
+		return time.Time{}, time.Time{}, fmt.Errorf("comparing periods requires from and to")
+	}
+	from, to = from.In(location), to.In(location)
+
+	switch mode {
+	case constants.ComparePreviousPeriod:
+		if isMidnight(from) && isMidnight(to) {
+			if from.Day() == 1 && to.Day() == 1 {
+				months := (to.Year()-from.Year())*12 + int(to.Month()-from.Month())
+				return from.AddDate(0, -months, 0), from, nil
+			}
+			return from.AddDate(0, 0, -calendarDays(from, to)), from, nil
+		}
+		return from.Add(-to.Sub(from)), from, nil
+	case constants.ComparePreviousYear:
+		return from.AddDate(-1, 0, 0), to.AddDate(-1, 0, 0), nil
+	default:
+		return time.Time{}, time.Time{}, fmt.Errorf("unsupported comparison %q", mode)
+	}
+}
+
+// isMidnight checks if t is the start of a day in its location
+func isMidnight(t time.Time) bool {
+	return t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0
+}
+
+// calendarDays returns the number of calendar days from the date of from to the date of to
+func calendarDays(from, to time.Time) int {
+	fromDate := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	toDate := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(toDate.Sub(fromDate) / (24 * time.Hour))
+}
+
+// CompareStats aligns the groups of the current and previous stats by their _id, see addComparison
+// Groups only found in the previous period are appended with empty current values.
+func CompareStats(current, previous []bson.M, aggregations []Aggregation) []bson.M {
+	previousByKey := make(map[string]bson.M, len(previous))
+	for _, group := range previous {
+		previousByKey[groupKey(group["_id"])] = group
+	}
+
+	results := make([]bson.M, 0, len(current))
+	for _, group := range current {
+		key := groupKey(group["_id"])
+		addComparison(group, previousByKey[key], aggregations)
+		delete(previousByKey, key)
+		results = append(results, group)
+	}
+	for _, group := range previous {
+		if _, ok := previousByKey[groupKey(group["_id"])]; !ok {
+			continue
+		}
+		result := emptyPoint(aggregations)
+		result["_id"] = group["_id"]
+		addComparison(result, group, aggregations)
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// CompareTimeSeries aligns the buckets of the current and previous series by position
+// The first bucket is compared with the first previous one and so on, the start of the previous
+// bucket is returned as previous_id. Buckets past the end of the previous series have no previous
+// values, previous buckets past the end of the current series are dropped.
+func CompareTimeSeries(current, previous []bson.M, aggregations []Aggregation) []bson.M {
+	for i, point := range current {
+		var previousPoint bson.M
+		if i < len(previous) {
+			previousPoint = previous[i]
+			point["previous_id"] = previousPoint["_id"]
+		}
+		addComparison(point, previousPoint, aggregations)
+	}
+	return current
+}
+
+// addComparison adds the previous value of every aggregation column of a result under previous,
+// with the absolute change under change and the change in percent of the previous value under
+// change_pct. Changes are null when either value is, or when the previous value is zero for percents.
+func addComparison(result, previous bson.M, aggregations []Aggregation) {
+	if previous == nil {
+		previous = emptyPoint(aggregations)
+	}
+
+	columns := make([]string, 0, len(aggregations))
+	for _, aggregation := range aggregations {
+		columns = append(columns, aggregation.Column())
+	}
+	if len(aggregations) == 1 {
+		columns = append(columns, "value")
+	}
+
+	previousValues := bson.M{}
+	changes := bson.M{}
+	percentChanges := bson.M{}
+	for _, column := range columns {
+		previousValues[column] = previous[column]
+		changes[column] = nil
+		percentChanges[column] = nil
+
+		currentValue, currentOk := numericValue(result[column])
+		previousValue, previousOk := numericValue(previous[column])
+		if !currentOk || !previousOk {
+			continue
+		}
+		changes[column] = currentValue - previousValue
+		if previousValue != 0 {
+			percentChanges[column] = (currentValue - previousValue) / previousValue * 100
+		}
+	}
+
+	result["previous"] = previousValues
+	result["change"] = changes
+	result["change_pct"] = percentChanges
+}
+
+// groupKey returns a map key identifying a group by its _id
+// A group's _id may decode as int32 in one period and int64 or double in the other, so numbers
+// are keyed as float64, inside compound keys as well. fmt prints maps with sorted keys, so
+// compound keys compare equal whatever their order.
+func groupKey(id interface{}) string {
+	id = normalizeGroupID(id)
+	return fmt.Sprintf("%T:%#v", id, id)
+}
+
+// normalizeGroupID converts the numbers of a group _id to float64
+func normalizeGroupID(id interface{}) interface{} {
+	if number, ok := toFloat64(id); ok {
+		return number
+	}
+
+	switch v := id.(type) {
+	case bson.M:
+		normalized := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			normalized[key] = normalizeGroupID(value)
+		}
+		return normalized
+	case bson.D:
+		normalized := make(map[string]interface{}, len(v))
+		for _, element := range v {
+			normalized[element.Key] = normalizeGroupID(element.Value)
+		}
+		return normalized
+	case bson.A:
+		normalized := make([]interface{}, len(v))
+		for i, value := range v {
+			normalized[i] = normalizeGroupID(value)
+		}
+		return normalized
+	default:
+		return id
+	}
+}
+
+// numericValue converts an aggregation result to a float64, including the counts computed here
+func numericValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	default:
+		return toFloat64(value)
+	}
+}
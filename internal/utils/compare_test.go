@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGroupKey(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b interface{}
+		same bool
+	}{
+		{"int32 and int64", int32(5), int64(5), true},
+		{"int32 and double", int32(5), 5.0, true},
+		{"decimal and int64", mustDecimal(t, "5"), int64(5), true},
+		{"different numbers", int64(5), 5.5, false},
+		{"number and string", int64(5), "5", false},
+		{"number and date", int64(5), primitive.DateTime(5), false},
+		{"null and string", nil, "", false},
+		{"compound keys in any order", bson.M{"d0": "US", "d1": int32(5)}, bson.M{"d1": int64(5), "d0": "US"}, true},
+		{"compound keys with a string number", bson.M{"d0": "US", "d1": int32(5)}, bson.M{"d0": "US", "d1": "5"}, false},
+		{"ordered and unordered documents", bson.D{{Key: "d0", Value: int32(1)}}, bson.M{"d0": 1.0}, true},
+		{"numbers inside arrays", bson.A{int32(1), "a"}, bson.A{1.0, "a"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := groupKey(tt.a) == groupKey(tt.b); same != tt.same {
+				t.Errorf("groupKey(%v) == groupKey(%v) is %v, want %v", tt.a, tt.b, same, tt.same)
+			}
+		})
+	}
+}
+
+func TestCompareStatsMixedNumericTypes(t *testing.T) {
+	aggregations := []Aggregation{{Op: "count"}}
+	current := []bson.M{{"_id": int32(5), "count": int32(10)}}
+	previous := []bson.M{{"_id": int64(5), "count": int32(4)}, {"_id": 7.0, "count": int32(2)}}
+
+	results := CompareStats(current, previous, aggregations)
+	if len(results) != 2 {
+		t.Fatalf("CompareStats() returned %d groups, want 2: %v", len(results), results)
+	}
+	if got := results[0]["previous"].(bson.M)["count"]; got != int32(4) {
+		t.Errorf("previous count of group 5 = %v, want 4", got)
+	}
+	if got := results[0]["change"].(bson.M)["count"]; got != 6.0 {
+		t.Errorf("change of group 5 = %v, want 6", got)
+	}
+	if got := results[1]["_id"]; got != 7.0 {
+		t.Errorf("group only in the previous period = %v, want 7", got)
+	}
+}
+
+func mustDecimal(t *testing.T, value string) primitive.Decimal128 {
+	decimal, err := primitive.ParseDecimal128(value)
+	if err != nil {
+		t.Fatalf("parse decimal %q: %v", value, err)
+	}
+	return decimal
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/kerimovok/go-pkg-utils/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrInvalidCursor is returned for cursors that are malformed or were not issued by this service
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks the position a keyset page continues from
+// It is handed to clients as an opaque, signed token
+type Cursor struct {
+	SortBy    string             `bson:"s"`
+	SortOrder string             `bson:"o"`
+	Value     interface{}        `bson:"v"` // sort field value of the boundary event, nil when missing
+	Id        primitive.ObjectID `bson:"i"` // id of the boundary event, breaks ties between equal values
+	Backward  bool               `bson:"b"` // true when the page ends before the boundary event
+}
+
+var (
+	cursorSecretOnce sync.Once
+	cursorSecret     []byte
+)
+
+// EncodeCursor serializes and signs a cursor
+func EncodeCursor(cursor Cursor) (string, error) {
+	payload, err := bson.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	token := append(payload, signCursor(payload)...)
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// DecodeCursor verifies and deserializes a cursor token
+func DecodeCursor(token string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(data) <= sha256.Size {
+		return nil, ErrInvalidCursor
+	}
+
+	payload, signature := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	if !hmac.Equal(signature, signCursor(payload)) {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+	if err := bson.Unmarshal(payload, &cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+func signCursor(payload []byte) []byte {
+	mac := hmac.New(sha256.New, getCursorSecret())
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// getCursorSecret returns the key cursors are signed with
+// Without CURSOR_SECRET a random key is used, so cursors only work on the instance that issued them
+func getCursorSecret() []byte {
+	cursorSecretOnce.Do(func() {
+		if secret := config.GetEnv("CURSOR_SECRET"); secret != "" {
+			cursorSecret = []byte(secret)
+			return
+		}
+
+		log.Printf("CURSOR_SECRET is not set, using a random key: cursors will not survive restarts or work across instances")
+		cursorSecret = make([]byte, 32)
+		if _, err := rand.Read(cursorSecret); err != nil {
+			log.Fatalf("failed to generate cursor secret: %v", err)
+		}
+	})
+	return cursorSecret
+}
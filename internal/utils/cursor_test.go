@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	id := primitive.NewObjectID()
+	createdAt := time.Date(2024, 3, 10, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		cursor Cursor
+		want   interface{} // Value once decoded
+	}{
+		{"string value", Cursor{SortBy: "name", SortOrder: "asc", Value: "signup", Id: id}, "signup"},
+		{"integer value", Cursor{SortBy: "properties.n", SortOrder: "desc", Value: int64(42), Id: id}, int64(42)},
+		{"date value", Cursor{SortBy: "created_at", SortOrder: "asc", Value: createdAt, Id: id}, primitive.NewDateTimeFromTime(createdAt)},
+		{"missing value", Cursor{SortBy: "occurred_at", SortOrder: "asc", Value: nil, Id: id, Backward: true}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := EncodeCursor(tt.cursor)
+			if err != nil {
+				t.Fatalf("EncodeCursor() error = %v", err)
+			}
+			got, err := DecodeCursor(token)
+			if err != nil {
+				t.Fatalf("DecodeCursor() error = %v", err)
+			}
+
+			want := tt.cursor
+			want.Value = tt.want
+			if !reflect.DeepEqual(*got, want) {
+				t.Errorf("DecodeCursor() = %+v, want %+v", *got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeCursorRejectsTampering(t *testing.T) {
+	token, err := EncodeCursor(Cursor{SortBy: "created_at", SortOrder: "asc", Value: "a", Id: primitive.NewObjectID()})
+	if err != nil {
+		t.Fatalf("EncodeCursor() error = %v", err)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		t.Fatalf("decode token: %v", err)
+	}
+	payload := data[:len(data)-sha256.Size]
+
+	// A cursor pointing elsewhere signed with another key
+	forged, err := bson.Marshal(Cursor{SortBy: "created_at", SortOrder: "desc", Value: "z", Id: primitive.NewObjectID()})
+	if err != nil {
+		t.Fatalf("marshal cursor: %v", err)
+	}
+	mac := hmac.New(sha256.New, []byte("not the secret"))
+	mac.Write(forged)
+
+	flip := func(index int) []byte {
+		tampered := append([]byte(nil), data...)
+		tampered[index] ^= 0x01
+		return tampered
+	}
+	encode := base64.RawURLEncoding.EncodeToString
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"not base64", "not a cursor!"},
+		{"padded base64", base64.URLEncoding.EncodeToString(data)},
+		{"signature only", encode(data[len(data)-sha256.Size:])},
+		{"payload only", encode(payload)},
+		{"truncated signature", encode(data[:len(data)-1])},
+		{"extra byte", encode(append(append([]byte(nil), data...), 0))},
+		{"payload byte flipped", encode(flip(len(payload) / 2))},
+		{"signature byte flipped", encode(flip(len(data) - 1))},
+		{"signed with another key", encode(append(forged, mac.Sum(nil)...))},
+		{"unsigned payload of another cursor", encode(append(forged, data[len(data)-sha256.Size:]...))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor, err := DecodeCursor(tt.token)
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("DecodeCursor() = %+v, %v, want ErrInvalidCursor", cursor, err)
+			}
+		})
+	}
+}
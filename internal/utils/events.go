@@ -2,13 +2,17 @@ package utils
 
 import (
 	"context"
+	"errors"
 	"events-api/internal/constants"
 	"events-api/internal/database"
 	"events-api/internal/models"
 	"fmt"
 	"log"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -19,14 +23,18 @@ import (
 // - sort: MongoDB sort specification
 // - page: Page number (1-based)
 // - limit: Maximum number of items per page
-func QueryEvents(ctx context.Context, filters bson.M, sort bson.D, page, limit int) ([]models.Event, error) {
+// Returns whether more events follow the page
+func QueryEvents(ctx context.Context, filters bson.M, sort bson.D, page, limit int) ([]models.Event, bool, error) {
 	skip, perPage := Pagination(page, limit)
 
+	// Fetch one extra event to know whether another page follows
 	opts := options.Find().
 		SetSort(sort).
 		SetSkip(int64(skip)).
-		SetLimit(int64(perPage))
+		SetLimit(int64(perPage + 1)).
+		SetMaxTime(constants.QueryTimeout)
 
+	filters = CombineFilters(filters, NotDeletedFilter())
 	collection := database.DBClient.Database().Collection(constants.EventsCollection)
 
 	// Add logging for debugging
@@ -35,147 +43,471 @@ func QueryEvents(ctx context.Context, filters bson.M, sort bson.D, page, limit i
 	cursor, err := collection.Find(ctx, filters, opts)
 	if err != nil {
 		log.Printf("failed to execute find query: %v", err)
-		return nil, err
+		return nil, false, err
 	}
 	defer cursor.Close(ctx)
 
 	var events []models.Event
 	if err = cursor.All(ctx, &events); err != nil {
 		log.Printf("failed to decode events: %v", err)
-		return nil, err
+		return nil, false, err
+	}
+
+	hasMore := len(events) > perPage
+	if hasMore {
+		events = events[:perPage]
 	}
 
 	log.Printf("retrieved %d events", len(events))
-	return events, nil
+	return events, hasMore, nil
 }
 
-// AggregateStats performs statistical aggregations on events
+// ErrCountTimeout is returned when counting events takes longer than constants.CountTimeout
+var ErrCountTimeout = errors.New("counting events timed out")
+
+// CountEvents counts the events matching filters, giving up after constants.CountTimeout
+// With estimated set and no filters the count is taken from collection metadata,
+// which is instant but includes soft deleted events and may be slightly off after unclean
+// shutdowns or on sharded clusters.
+// Returns whether the count is an estimate.
+func CountEvents(ctx context.Context, filters bson.M, estimated bool) (int64, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, constants.CountTimeout)
+	defer cancel()
+
+	collection := database.DBClient.Database().Collection(constants.EventsCollection)
+
+	var (
+		total int64
+		err   error
+	)
+	useEstimate := estimated && len(filters) == 0
+	if useEstimate {
+		total, err = collection.EstimatedDocumentCount(ctx, options.EstimatedDocumentCount().SetMaxTime(constants.CountTimeout))
+	} else {
+		total, err = collection.CountDocuments(ctx, CombineFilters(filters, NotDeletedFilter()), options.Count().SetMaxTime(constants.CountTimeout))
+	}
+	if err != nil {
+		if mongo.IsTimeout(err) || errors.Is(err, context.DeadlineExceeded) {
+			return 0, false, ErrCountTimeout
+		}
+		return 0, false, err
+	}
+
+	return total, useEstimate, nil
+}
+
+// EventsPage is a page of events retrieved with keyset pagination
+type EventsPage struct {
+	Events     []models.Event
+	NextCursor string // empty when there are no later events
+	PrevCursor string // empty when there are no earlier events
+}
+
+// QueryEventsByCursor retrieves events with keyset pagination on (sortBy, _id)
+// Unlike page based pagination the cost does not grow with the position in the result set,
+// and events inserted while paging do not shift later pages.
 // Parameters:
 // - ctx: Context for the operation
 // - filters: MongoDB query filters
-// - groupBy: Field to group results by
-// - aggregates: Type of aggregation to perform (count, sum, avg)
-func AggregateStats(ctx context.Context, filters bson.M, groupBy, aggregates string) ([]bson.M, error) {
-	if groupBy == "" {
-		return nil, fmt.Errorf("groupBy field is required")
+// - sortBy, sortOrder: Sort specification, must match the cursor's when one is given
+// - cursor: Position to continue from, nil for the first page
+// - limit: Maximum number of items per page
+func QueryEventsByCursor(ctx context.Context, filters bson.M, sortBy, sortOrder string, cursor *Cursor, limit int) (*EventsPage, error) {
+	field := SortField(sortBy)
+	ascending := sortOrder != constants.SortOrderDesc
+	backward := cursor != nil && cursor.Backward
+
+	// Walking backwards reads in reverse order and flips the page afterwards
+	queryOrder := sortOrder
+	if backward {
+		queryOrder = constants.SortOrderDesc
+		if !ascending {
+			queryOrder = constants.SortOrderAsc
+		}
+	}
+
+	filters = CombineFilters(filters, NotDeletedFilter())
+	if cursor != nil {
+		filters = CombineFilters(filters, keysetFilter(field, cursor.Value, cursor.Id, ascending != backward))
+	}
+
+	// Fetch one extra event to know whether another page follows
+	opts := options.Find().
+		SetSort(BuildSortOptions(sortBy, queryOrder)).
+		SetLimit(int64(limit + 1)).
+		SetMaxTime(constants.QueryTimeout)
+
+	collection := database.DBClient.Database().Collection(constants.EventsCollection)
+
+	log.Printf("Querying events with filters: %+v, sortBy: %s %s, cursor: %v, limit: %d", filters, sortBy, sortOrder, cursor != nil, limit)
+
+	mongoCursor, err := collection.Find(ctx, filters, opts)
+	if err != nil {
+		log.Printf("failed to execute find query: %v", err)
+		return nil, err
 	}
+	defer mongoCursor.Close(ctx)
 
-	pipeline := []bson.M{}
+	events := []models.Event{}
+	if err = mongoCursor.All(ctx, &events); err != nil {
+		log.Printf("failed to decode events: %v", err)
+		return nil, err
+	}
 
-	// Match stage for filters
-	if len(filters) > 0 {
-		pipeline = append(pipeline, bson.M{"$match": filters})
+	hasMore := len(events) > limit
+	if hasMore {
+		events = events[:limit]
+	}
+	if backward {
+		for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+			events[i], events[j] = events[j], events[i]
+		}
 	}
 
-	// Group stage
-	groupStage := bson.M{
-		"_id": "$" + groupBy,
+	page := &EventsPage{Events: events}
+	if len(events) == 0 {
+		return page, nil
 	}
 
-	// Add aggregation operations
-	switch aggregates {
-	case constants.AggregationCount:
-		groupStage["value"] = bson.M{"$sum": 1}
-	case constants.AggregationSum:
-		groupStage["value"] = bson.M{"$sum": "$value"}
-	case constants.AggregationAvg:
-		groupStage["value"] = bson.M{"$avg": "$value"}
-	default:
-		groupStage["value"] = bson.M{"$sum": 1} // Default to count
+	hasNext, hasPrev := hasMore, cursor != nil
+	if backward {
+		hasNext, hasPrev = true, hasMore
 	}
 
-	pipeline = append(pipeline,
-		bson.M{"$group": groupStage},
-		bson.M{"$sort": bson.M{"_id": 1}},
-	)
+	if hasNext {
+		last := events[len(events)-1]
+		if page.NextCursor, err = EncodeCursor(Cursor{SortBy: sortBy, SortOrder: sortOrder, Value: sortValue(last, field), Id: last.Id}); err != nil {
+			return nil, err
+		}
+	}
+	if hasPrev {
+		first := events[0]
+		if page.PrevCursor, err = EncodeCursor(Cursor{SortBy: sortBy, SortOrder: sortOrder, Value: sortValue(first, field), Id: first.Id, Backward: true}); err != nil {
+			return nil, err
+		}
+	}
 
+	log.Printf("retrieved %d events", len(events))
+	return page, nil
+}
+
+// keysetFilter matches the events that come after (value, id) in the given direction
+// MongoDB sorts missing and null values before everything else, which the conditions account for
+func keysetFilter(field string, value interface{}, id primitive.ObjectID, ascending bool) bson.M {
+	idOperator := "$gt"
+	valueOperator := "$gt"
+	if !ascending {
+		idOperator = "$lt"
+		valueOperator = "$lt"
+	}
+
+	if field == "_id" {
+		return bson.M{"_id": bson.M{idOperator: id}}
+	}
+
+	if value == nil {
+		if ascending {
+			return bson.M{"$or": bson.A{
+				bson.M{field: bson.M{"$ne": nil}},
+				bson.M{field: nil, "_id": bson.M{idOperator: id}},
+			}}
+		}
+		return bson.M{field: nil, "_id": bson.M{idOperator: id}}
+	}
+
+	conditions := bson.A{
+		bson.M{field: bson.M{valueOperator: value}},
+		bson.M{field: value, "_id": bson.M{idOperator: id}},
+	}
+	if !ascending {
+		conditions = append(conditions, bson.M{field: nil})
+	}
+	return bson.M{"$or": conditions}
+}
+
+// sortValue returns the value of a sortable field of an event, nil when it is not stored
+func sortValue(event models.Event, field string) interface{} {
+	var value time.Time
+	switch field {
+	case "_id":
+		return event.Id
+	case constants.TimeFieldCreatedAt:
+		value = event.CreatedAt
+	case constants.TimeFieldOccurredAt:
+		value = event.OccurredAt
+	case "updated_at":
+		value = event.UpdatedAt
+	}
+	if value.IsZero() {
+		return nil
+	}
+	return value
+}
+
+// FindEventByID retrieves a single event by its ObjectID
+// Returns nil without an error when the event does not exist
+func FindEventByID(ctx context.Context, id primitive.ObjectID) (*models.Event, error) {
+	collection := database.DBClient.Database().Collection(constants.EventsCollection)
+
+	var event models.Event
+	if err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&event); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &event, nil
+}
+
+// UpdateEvent writes the properties and schema fields of a modified event
+// The write only happens when the stored event still has previousUpdatedAt and is not deleted,
+// so concurrent modifications are detected instead of silently overwritten.
+// Returns false when no matching event was found.
+func UpdateEvent(ctx context.Context, event *models.Event, previousUpdatedAt time.Time) (bool, error) {
 	collection := database.DBClient.Database().Collection(constants.EventsCollection)
-	cursor, err := collection.Aggregate(ctx, pipeline)
+
+	set := bson.M{
+		"properties": event.Properties,
+		"updated_at": event.UpdatedAt,
+	}
+	unset := bson.M{}
+	if event.SchemaVersion != 0 {
+		set["schema_version"] = event.SchemaVersion
+	} else {
+		unset["schema_version"] = ""
+	}
+	if len(event.SchemaViolations) > 0 {
+		set["schema_violations"] = event.SchemaViolations
+	} else {
+		unset["schema_violations"] = ""
+	}
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	filter := CombineFilters(bson.M{"_id": event.Id, "updated_at": previousUpdatedAt}, NotDeletedFilter())
+	result, err := collection.UpdateOne(ctx, filter, update)
 	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount > 0, nil
+}
+
+// DeleteEvent deletes an event, or only marks it with deleted_at when soft is set
+// Returns the event as it was before, nil when it does not exist or was already soft deleted
+func DeleteEvent(ctx context.Context, id primitive.ObjectID, soft bool) (*models.Event, error) {
+	collection := database.DBClient.Database().Collection(constants.EventsCollection)
+
+	var event models.Event
+	var err error
+	if !soft {
+		err = collection.FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(&event)
+	} else {
+		now := time.Now()
+		err = collection.FindOneAndUpdate(ctx,
+			CombineFilters(bson.M{"_id": id}, NotDeletedFilter()),
+			bson.M{"$set": bson.M{"deleted_at": now, "updated_at": now}},
+		).Decode(&event)
+	}
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
 		return nil, err
 	}
-	defer cursor.Close(ctx)
 
-	var results []bson.M
-	if err = cursor.All(ctx, &results); err != nil {
+	return &event, nil
+}
+
+// AggregateStats performs statistical aggregations on events
+// Parameters:
+// - ctx: Context for the operation
+// - filters: MongoDB query filters
+// - groupBy: Fields to group results by, several fields group by their combination
+// - aggregations: Aggregations to perform, see Aggregation
+//
+// With a single groupBy field the _id of each result is the field's value, with several it is
+// a document mapping every field to its value (e.g. {"properties.country": "US", "name": "signup"}).
+func AggregateStats(ctx context.Context, filters bson.M, groupBy []string, aggregations []Aggregation) ([]bson.M, error) {
+	if len(groupBy) == 0 {
+		return nil, fmt.Errorf("groupBy field is required")
+	}
+
+	if len(groupBy) == 1 {
+		return aggregateGroups(ctx, filters, "$"+groupBy[0], aggregations)
+	}
+
+	// Group keys can't contain dots, so the dimensions are numbered and renamed afterwards
+	groupKey := bson.D{}
+	for i, field := range groupBy {
+		groupKey = append(groupKey, bson.E{Key: fmt.Sprintf("d%d", i), Value: bson.M{"$ifNull": bson.A{"$" + field, nil}}})
+	}
+
+	results, err := aggregateGroups(ctx, filters, groupKey, aggregations)
+	if err != nil {
 		return nil, err
 	}
 
+	nameDimensions(results, groupBy)
 	return results, nil
 }
 
+// nameDimensions replaces the numbered keys of compound group keys with the fields they stand for
+func nameDimensions(results []bson.M, groupBy []string) {
+	for _, result := range results {
+		key, _ := result["_id"].(bson.M)
+		dimensions := make(bson.M, len(groupBy))
+		for i, field := range groupBy {
+			dimensions[field] = key[fmt.Sprintf("d%d", i)]
+		}
+		result["_id"] = dimensions
+	}
+}
+
 // AggregateTimeSeries performs time-based aggregations on events
 // Parameters:
 // - ctx: Context for the operation
 // - filters: MongoDB query filters
-// - interval: Time interval for grouping (hour, day, week, month)
-// - aggregates: Type of aggregation to perform (count, sum, avg)
-func AggregateTimeSeries(ctx context.Context, filters bson.M, interval, aggregates string) ([]bson.M, error) {
-	if interval == "" {
+// - bucketing: How events are bucketed in time, see TimeBucketing
+// - aggregations: Aggregations to perform, see Aggregation
+// - windows: Window operations computed over the buckets of every aggregation, see WindowOp
+//
+// Series bounded by From and To are contiguous: buckets without events are filled in, see TimeBucketing.
+// The _id of each result is the start of its bucket as an RFC3339 timestamp in the bucketing's location.
+func AggregateTimeSeries(ctx context.Context, filters bson.M, bucketing TimeBucketing, aggregations []Aggregation, windows []WindowOp) ([]bson.M, error) {
+	if bucketing.Interval.Unit == "" {
 		return nil, fmt.Errorf("interval parameter is required")
 	}
 
-	pipeline := []bson.M{}
+	if err := bucketing.checkBucketCount(); err != nil {
+		return nil, err
+	}
+	if err := ValidateWindowOps(windows, aggregations); err != nil {
+		return nil, err
+	}
 
-	// Match stage for filters
-	if len(filters) > 0 {
-		pipeline = append(pipeline, bson.M{"$match": filters})
+	var stages []bson.M
+	if len(windows) > 0 {
+		// Window operations may read buckets before the start of the series
+		filters = CombineFilters(filters, TimeRangeFilter(bucketing.TimeField, bucketing.windowFrom(windows), bucketing.To))
+		stages = bucketing.windowStages(aggregations, windows)
+	} else {
+		filters = CombineFilters(filters, bucketing.rangeFilter())
 	}
 
-	// Group by time interval
-	groupStage := bson.M{
-		"_id": bson.M{
-			"$dateToString": bson.M{
-				"format": getTimeFormat(interval),
-				"date":   "$created_at",
-			},
-		},
+	results, err := aggregateGroups(ctx, filters, bucketing.expr(), aggregations, stages...)
+	if err != nil {
+		return nil, err
 	}
 
-	// Add aggregation operations
-	switch aggregates {
-	case constants.AggregationCount:
-		groupStage["value"] = bson.M{"$sum": 1}
-	case constants.AggregationSum:
-		groupStage["value"] = bson.M{"$sum": "$value"}
-	case constants.AggregationAvg:
-		groupStage["value"] = bson.M{"$avg": "$value"}
-	default:
-		groupStage["value"] = bson.M{"$sum": 1} // Default to count
+	points, err := bucketing.fill(results, aggregations)
+	if err != nil {
+		return nil, err
 	}
+	fillWindows(points, aggregations, windows)
+	return points, nil
+}
 
-	pipeline = append(pipeline,
-		bson.M{"$group": groupStage},
-		bson.M{"$sort": bson.M{"_id": 1}},
-	)
+// Series is the time series of the events sharing one value of a breakdown property
+type Series struct {
+	Key    interface{} `json:"key"`             // value of the breakdown property, nil for the other series
+	Other  bool        `json:"other,omitempty"` // set on the series combining all values outside the top N
+	Points []bson.M    `json:"points"`
+}
+
+// AggregateTimeSeriesBreakdown performs time-based aggregations split by the values of a property
+// The topN values with the most events get a series of their own, ordered by their number of events.
+// Events with any other value are aggregated into a single trailing series marked as other.
+// Parameters are those of AggregateTimeSeries, plus:
+// - breakdown: Field whose values split the series
+// - topN: Number of values with a series of their own
+func AggregateTimeSeriesBreakdown(ctx context.Context, filters bson.M, bucketing TimeBucketing, aggregations []Aggregation, breakdown string, topN int) ([]Series, error) {
+	if bucketing.Interval.Unit == "" {
+		return nil, fmt.Errorf("interval parameter is required")
+	}
+
+	if err := bucketing.checkBucketCount(); err != nil {
+		return nil, err
+	}
+
+	filters = CombineFilters(filters, bucketing.rangeFilter())
+	breakdownValue := bson.M{"$ifNull": bson.A{"$" + breakdown, nil}}
 
+	// Find the values with the most events
 	collection := database.DBClient.Database().Collection(constants.EventsCollection)
-	cursor, err := collection.Aggregate(ctx, pipeline)
+	cursor, err := collection.Aggregate(ctx, []bson.M{
+		{"$match": CombineFilters(filters, NotDeletedFilter())},
+		{"$group": bson.M{"_id": breakdownValue, "count": bson.M{"$sum": 1}}},
+		{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+		{"$limit": topN},
+	}, options.Aggregate().SetMaxTime(constants.QueryTimeout))
 	if err != nil {
 		return nil, err
 	}
 	defer cursor.Close(ctx)
 
-	var results []bson.M
-	if err = cursor.All(ctx, &results); err != nil {
+	var top []struct {
+		Value interface{} `bson:"_id"`
+	}
+	if err = cursor.All(ctx, &top); err != nil {
 		return nil, err
 	}
+	if len(top) == 0 {
+		return []Series{}, nil
+	}
 
-	return results, nil
+	topValues := make(bson.A, len(top))
+	series := make([]Series, len(top))
+	for i, value := range top {
+		topValues[i] = value.Value
+		series[i] = Series{Key: value.Value, Points: []bson.M{}}
+	}
+	other := Series{Other: true, Points: []bson.M{}}
+
+	// The series is identified by the value's rank, -1 for values outside the top N
+	// The values are literal, strings such as "$100" would otherwise be read as field paths
+	groupKey := bson.D{
+		{Key: "t", Value: bucketing.expr()},
+		{Key: "s", Value: bson.M{"$indexOfArray": bson.A{bson.M{"$literal": topValues}, breakdownValue}}},
+	}
+
+	results, err := aggregateGroups(ctx, filters, groupKey, aggregations)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		key, _ := result["_id"].(bson.M)
+		result["_id"] = key["t"]
+
+		index, _ := key["s"].(int32)
+		if index < 0 || int(index) >= len(series) {
+			other.Points = append(other.Points, result)
+			continue
+		}
+		series[index].Points = append(series[index].Points, result)
+	}
+
+	if len(other.Points) > 0 {
+		series = append(series, other)
+	}
+	for i := range series {
+		if series[i].Points, err = bucketing.fill(series[i].Points, aggregations); err != nil {
+			return nil, err
+		}
+	}
+	return series, nil
 }
 
-// getTimeFormat returns the date format string for MongoDB based on the interval
-func getTimeFormat(interval string) string {
-	switch interval {
-	case constants.IntervalHour:
-		return constants.TimeFormatHour
-	case constants.IntervalDay:
-		return constants.TimeFormatDay
-	case constants.IntervalWeek:
-		return constants.TimeFormatWeek
-	case constants.IntervalMonth:
-		return constants.TimeFormatMonth
-	default:
-		return constants.TimeFormatDay // Default to daily
+// TimeFieldExpr returns the aggregation expression reading the given time field
+// Events stored before occurred_at existed fall back to their created_at
+func TimeFieldExpr(timeField string) interface{} {
+	if timeField == constants.TimeFieldOccurredAt {
+		return bson.M{"$ifNull": bson.A{"$" + constants.TimeFieldOccurredAt, "$" + constants.TimeFieldCreatedAt}}
 	}
+	return "$" + constants.TimeFieldCreatedAt
 }
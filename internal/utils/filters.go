@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// CombineFilters joins MongoDB filters with $and, skipping empty ones
+func CombineFilters(filters ...bson.M) bson.M {
+	nonEmpty := make([]bson.M, 0, len(filters))
+	for _, filter := range filters {
+		if len(filter) > 0 {
+			nonEmpty = append(nonEmpty, filter)
+		}
+	}
+
+	switch len(nonEmpty) {
+	case 0:
+		return bson.M{}
+	case 1:
+		return nonEmpty[0]
+	default:
+		return bson.M{"$and": nonEmpty}
+	}
+}
+
+// NameFilter builds a filter on the event name from a comma-separated list of names
+// Returns nil when no name is given
+func NameFilter(names string) bson.M {
+	var values []string
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			values = append(values, name)
+		}
+	}
+
+	switch len(values) {
+	case 0:
+		return nil
+	case 1:
+		return bson.M{"name": values[0]}
+	default:
+		return bson.M{"name": bson.M{"$in": values}}
+	}
+}
+
+// NotDeletedFilter matches events that have not been soft deleted
+func NotDeletedFilter() bson.M {
+	return bson.M{"deleted_at": nil}
+}
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"math"
+	"math/bits"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// hyperLogLogPrecision sets 2^14 registers per sketch, about 16KB of memory
+// for a standard error of roughly 0.8%
+const hyperLogLogPrecision = 14
+
+// hashSeed is shared by all sketches so that values hash the same way within the process
+var hashSeed = maphash.MakeSeed()
+
+// hyperLogLog is a HyperLogLog sketch estimating the number of distinct values added to it
+type hyperLogLog struct {
+	registers []uint8
+}
+
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{registers: make([]uint8, 1<<hyperLogLogPrecision)}
+}
+
+// Add records the 64-bit hash of a value
+func (h *hyperLogLog) Add(hash uint64) {
+	index := hash >> (64 - hyperLogLogPrecision)
+	// The sentinel bit bounds the rank when the remaining bits are all zero
+	rest := hash<<hyperLogLogPrecision | 1<<(hyperLogLogPrecision-1)
+	rank := uint8(bits.LeadingZeros64(rest) + 1)
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+}
+
+// Count returns the estimated number of distinct values
+func (h *hyperLogLog) Count() uint64 {
+	m := float64(len(h.registers))
+
+	sum := 0.0
+	zeros := 0
+	for _, register := range h.registers {
+		sum += 1 / float64(uint64(1)<<register)
+		if register == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+
+	// Linear counting is more accurate while many registers are still empty
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return uint64(estimate + 0.5)
+}
+
+// hashValue hashes a BSON value for a sketch
+// Numbers are hashed by their value so that e.g. 1 and 1.0 count as the same value, as they do in MongoDB
+func hashValue(value bson.RawValue) uint64 {
+	var number float64
+	switch value.Type {
+	case bsontype.Int32:
+		number = float64(value.Int32())
+	case bsontype.Int64:
+		number = float64(value.Int64())
+	case bsontype.Double:
+		number = value.Double()
+	default:
+		return maphash.Bytes(hashSeed, append([]byte{byte(value.Type)}, value.Value...))
+	}
+
+	var buf [9]byte
+	buf[0] = byte(bsontype.Double)
+	binary.LittleEndian.PutUint64(buf[1:], math.Float64bits(number))
+	return maphash.Bytes(hashSeed, buf[:])
+}
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// splitMix64 returns well distributed 64-bit hashes that are the same on every run, unlike hashValue
+func splitMix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+func TestHyperLogLogErrorBounds(t *testing.T) {
+	// The standard error with 2^14 registers is 1.04/sqrt(2^14), about 0.8%
+	standardError := 1.04 / math.Sqrt(float64(uint64(1)<<hyperLogLogPrecision))
+
+	tests := []struct {
+		distinct  int
+		tolerance float64 // relative error allowed
+	}{
+		{0, 0},
+		{1, 0},
+		{10, 0},
+		{100, 0.01}, // linear counting is near exact while most registers are empty
+		{1000, 0.01},
+		{10000, 3 * standardError},
+		{50000, 3 * standardError},
+		{100000, 3 * standardError},
+		{1000000, 3 * standardError},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.distinct), func(t *testing.T) {
+			sketch := newHyperLogLog()
+			for i := 0; i < tt.distinct; i++ {
+				sketch.Add(splitMix64(uint64(i)))
+			}
+
+			got := sketch.Count()
+			relative := math.Abs(float64(got)-float64(tt.distinct)) / math.Max(float64(tt.distinct), 1)
+			if relative > tt.tolerance {
+				t.Errorf("Count() = %d for %d distinct values, relative error %.4f > %.4f", got, tt.distinct, relative, tt.tolerance)
+			}
+		})
+	}
+}
+
+func TestHyperLogLogIgnoresDuplicates(t *testing.T) {
+	once, repeated := newHyperLogLog(), newHyperLogLog()
+	for i := 0; i < 5000; i++ {
+		once.Add(splitMix64(uint64(i)))
+		for j := 0; j < 3; j++ {
+			repeated.Add(splitMix64(uint64(i)))
+		}
+	}
+
+	if once.Count() != repeated.Count() {
+		t.Errorf("Count() = %d with duplicates, want %d", repeated.Count(), once.Count())
+	}
+}
+
+func TestHashValue(t *testing.T) {
+	raw := func(t *testing.T, value interface{}) bson.RawValue {
+		kind, data, err := bson.MarshalValue(value)
+		if err != nil {
+			t.Fatalf("marshal %v: %v", value, err)
+		}
+		return bson.RawValue{Type: kind, Value: data}
+	}
+
+	tests := []struct {
+		name string
+		a, b interface{}
+		same bool
+	}{
+		{"int32 and int64", int32(7), int64(7), true},
+		{"int64 and double", int64(7), 7.0, true},
+		{"different numbers", int64(7), int64(8), false},
+		{"number and string", int64(7), "7", false},
+		{"equal strings", "signup", "signup", true},
+		{"different strings", "signup", "login", false},
+		{"string and null", "", primitive.Null{}, false},
+		{"bool values", true, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := hashValue(raw(t, tt.a)) == hashValue(raw(t, tt.b)); same != tt.same {
+				t.Errorf("hashValue(%v) == hashValue(%v) is %v, want %v", tt.a, tt.b, same, tt.same)
+			}
+		})
+	}
+}
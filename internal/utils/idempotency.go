@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"events-api/internal/constants"
+	"events-api/internal/database"
+	"events-api/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrIdempotencyKeyInProgress is returned when a key is claimed but its event is not stored yet
+var ErrIdempotencyKeyInProgress = errors.New("a request with this idempotency key is still being processed")
+
+// ClaimIdempotencyKey reserves key for the event with the given id
+// Returns the previously created event when the key was already used within the expiry window,
+// or nil when the key was claimed and the caller should go on to insert the event.
+// A claim whose event still isn't stored after IdempotencyClaimGracePeriod was abandoned, by a
+// crash between the claim and the insert or a hard delete of the event, and is taken over.
+func ClaimIdempotencyKey(ctx context.Context, key string, eventID primitive.ObjectID) (*models.Event, error) {
+	collection := database.DBClient.Database().Collection(constants.IdempotencyKeysCollection)
+
+	now := time.Now()
+	_, err := collection.InsertOne(ctx, models.IdempotencyKey{
+		Key:       key,
+		EventId:   eventID,
+		ClaimedAt: now,
+		CreatedAt: now,
+	})
+	if err == nil {
+		return nil, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil, err
+	}
+
+	var claimed models.IdempotencyKey
+	if err := collection.FindOne(ctx, bson.M{"_id": key}).Decode(&claimed); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// The key expired between the insert and the lookup
+			return nil, ErrIdempotencyKeyInProgress
+		}
+		return nil, err
+	}
+
+	event, err := FindEventByID(ctx, claimed.EventId)
+	if err != nil {
+		return nil, err
+	}
+	if event != nil {
+		return event, nil
+	}
+
+	if time.Since(claimed.ClaimedAt) < constants.IdempotencyClaimGracePeriod {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+
+	// Take the claim over unless another request did since it was read
+	err = collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": key, "event_id": claimed.EventId},
+		bson.M{"$set": bson.M{"event_id": eventID, "claimed_at": now, "created_at": now}},
+	).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// ReleaseIdempotencyKey removes a claimed key so that the request can be retried
+func ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	collection := database.DBClient.Database().Collection(constants.IdempotencyKeysCollection)
+	_, err := collection.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"events-api/internal/constants"
+	"events-api/internal/database"
+	"events-api/internal/models"
+	"events-api/internal/requests"
+	"fmt"
+	"time"
+
+	"github.com/kerimovok/go-pkg-utils/config"
+	"github.com/kerimovok/go-pkg-utils/validator"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NewEvent builds an event document from a validated create request
+// receivedAt is when the service first received the event and is the reference for clock skew correction.
+// Returns validation errors when the client supplied timestamp falls outside the accepted window.
+func NewEvent(input requests.CreateEventRequest, receivedAt time.Time) (models.Event, validator.ValidationErrors) {
+	now := time.Now()
+
+	occurredAt, validationErrors := resolveOccurredAt(input.Timestamp, input.SentAt, receivedAt)
+	if validationErrors.HasErrors() {
+		return models.Event{}, validationErrors
+	}
+
+	return models.Event{
+		Id:         primitive.NewObjectID(),
+		Name:       input.Name,
+		Properties: input.Properties,
+		OccurredAt: occurredAt,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}, nil
+}
+
+// resolveOccurredAt determines when an event happened
+// Without a client timestamp the receive time is used. When sent_at is also given, the difference
+// between receive time and sent_at is treated as the client's clock skew and added to the timestamp.
+func resolveOccurredAt(timestamp, sentAt *requests.Timestamp, receivedAt time.Time) (time.Time, validator.ValidationErrors) {
+	if timestamp == nil || timestamp.IsZero() {
+		return receivedAt, nil
+	}
+
+	occurredAt := timestamp.Time
+	if sentAt != nil && !sentAt.IsZero() {
+		occurredAt = occurredAt.Add(receivedAt.Sub(sentAt.Time))
+	}
+
+	maxPastAge := time.Duration(config.GetEnvInt("EVENT_MAX_PAST_AGE", constants.DefaultMaxEventPastAge)) * time.Second
+	maxFutureSkew := time.Duration(config.GetEnvInt("EVENT_MAX_FUTURE_SKEW", constants.DefaultMaxEventFutureSkew)) * time.Second
+
+	if occurredAt.Before(receivedAt.Add(-maxPastAge)) {
+		return time.Time{}, validator.ValidationErrors{{
+			Field:   "timestamp",
+			Message: fmt.Sprintf("timestamp must not be more than %s in the past", maxPastAge),
+			Value:   timestamp.Format(time.RFC3339Nano),
+		}}
+	}
+	if occurredAt.After(receivedAt.Add(maxFutureSkew)) {
+		return time.Time{}, validator.ValidationErrors{{
+			Field:   "timestamp",
+			Message: fmt.Sprintf("timestamp must not be more than %s in the future", maxFutureSkew),
+			Value:   timestamp.Format(time.RFC3339Nano),
+		}}
+	}
+
+	return occurredAt.UTC(), nil
+}
+
+// InsertEvents writes events with a single unordered InsertMany
+// Returns the write error of every event that failed, keyed by its index in events,
+// so callers can report partial failures. A non-nil error means the whole batch failed.
+func InsertEvents(ctx context.Context, events []models.Event) (map[int]error, error) {
+	if len(events) == 0 {
+		return nil, nil
+	}
+
+	docs := make([]interface{}, len(events))
+	for i := range events {
+		docs[i] = events[i]
+	}
+
+	collection := database.DBClient.Database().Collection(constants.EventsCollection)
+	_, err := collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err == nil {
+		return nil, nil
+	}
+
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil && len(bulkErr.WriteErrors) > 0 {
+		failed := make(map[int]error, len(bulkErr.WriteErrors))
+		for _, writeErr := range bulkErr.WriteErrors {
+			failed[writeErr.Index] = writeErr
+		}
+		return failed, nil
+	}
+
+	return nil, err
+}
@@ -0,0 +1,43 @@
+package utils
+
+import "go.mongodb.org/mongo-driver/bson"
+
+// MergePatch applies a JSON merge patch (RFC 7386) to target and returns the result
+// Null values remove keys, objects are merged recursively and any other value replaces
+// the existing one. target is left untouched.
+func MergePatch(target, patch map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(target)+len(patch))
+	for key, value := range target {
+		result[key] = value
+	}
+
+	for key, value := range patch {
+		if value == nil {
+			delete(result, key)
+			continue
+		}
+
+		patchObject, ok := asObject(value)
+		if !ok {
+			result[key] = value
+			continue
+		}
+
+		targetObject, _ := asObject(result[key])
+		result[key] = MergePatch(targetObject, patchObject)
+	}
+
+	return result
+}
+
+// asObject returns value as a map when it is a JSON or BSON document
+func asObject(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, true
+	case bson.M:
+		return v, true
+	default:
+		return nil, false
+	}
+}
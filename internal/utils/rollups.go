@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"context"
+	"events-api/internal/constants"
+	"events-api/internal/database"
+	"events-api/internal/models"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Rollup documents hold the counters of a rollup (see models.Rollup) for one time bucket and
+// combination of dimension values:
+//
+//	{r: rollup id, g: generation, b: bucket start, d: {d0: value, d1: value, ...}, m: {count, sum_<field>, n_<field>}, bm: {...}, bp: pass}
+//
+// Dimensions are numbered in the order the rollup declares them and are null when an event
+// doesn't have them. m is incremented on ingest, bm holds the counters of the events created
+// before the rollup and is overwritten by every pass of its build, bp naming the pass. Only the
+// documents of the current generation of a rollup are read, a rebuild counts into a new one.
+// n_<field> is the number of numeric values summed into sum_<field>, which averages divide by.
+// Values are read like NumericFieldExpr does, so rollups answer exactly what scanning the events
+// would.
+
+// RollupCollection returns the collection holding the documents of the rollups of a granularity
+func RollupCollection(granularity string) string {
+	if granularity == constants.RollupGranularityDay {
+		return constants.RollupsDailyCollection
+	}
+	return constants.RollupsHourlyCollection
+}
+
+// RollupBucketing returns the bucketing of the documents of a rollup, UTC hours or days
+func RollupBucketing(rollup models.Rollup) TimeBucketing {
+	return TimeBucketing{
+		Interval:  Interval{Unit: rollup.Granularity, BinSize: 1},
+		TimeField: rollup.TimeField,
+		Location:  time.UTC,
+	}
+}
+
+// RollupDimensionKey returns the key of a dimension within the d document of a rollup, e.g. d0
+// Returns an empty string when the rollup doesn't have the dimension
+func RollupDimensionKey(rollup models.Rollup, field string) string {
+	index := slices.Index(rollup.Dimensions, field)
+	if index < 0 {
+		return ""
+	}
+	return fmt.Sprintf("d%d", index)
+}
+
+// RollupSumCounter returns the counter of the sum of a metric field, e.g. sum_amount
+func RollupSumCounter(field string) string {
+	return Aggregation{Op: constants.AggregationSum, Field: field}.Column()
+}
+
+// RollupValuesCounter returns the counter of the number of values of a metric field summed, e.g. n_amount
+func RollupValuesCounter(field string) string {
+	return Aggregation{Op: "n", Field: field}.Column()
+}
+
+// AggregateRollupStats answers AggregateStats from the documents of a rollup
+// groupBy must be dimensions of the rollup and aggregations counts, or sums and averages of its
+// metric fields. from and to must be at the start of buckets of the rollup, zero for unbounded.
+func AggregateRollupStats(ctx context.Context, rollup models.Rollup, groupBy []string, aggregations []Aggregation, from, to time.Time) ([]bson.M, error) {
+	if len(groupBy) == 0 {
+		return nil, fmt.Errorf("groupBy field is required")
+	}
+
+	if len(groupBy) == 1 {
+		return aggregateRollup(ctx, rollup, from, to, "$d."+RollupDimensionKey(rollup, groupBy[0]), aggregations)
+	}
+
+	groupKey := bson.D{}
+	for i, field := range groupBy {
+		groupKey = append(groupKey, bson.E{Key: fmt.Sprintf("d%d", i), Value: "$d." + RollupDimensionKey(rollup, field)})
+	}
+
+	results, err := aggregateRollup(ctx, rollup, from, to, groupKey, aggregations)
+	if err != nil {
+		return nil, err
+	}
+
+	nameDimensions(results, groupBy)
+	return results, nil
+}
+
+// AggregateRollupTimeSeries answers AggregateTimeSeries from the documents of a rollup
+// The bucketing must be in UTC with buckets made of whole buckets of the rollup, and From and To
+// at the start of buckets of the rollup. Aggregations are restricted as for AggregateRollupStats.
+func AggregateRollupTimeSeries(ctx context.Context, rollup models.Rollup, bucketing TimeBucketing, aggregations []Aggregation, windows []WindowOp) ([]bson.M, error) {
+	if bucketing.Interval.Unit == "" {
+		return nil, fmt.Errorf("interval parameter is required")
+	}
+
+	if err := bucketing.checkBucketCount(); err != nil {
+		return nil, err
+	}
+	if err := ValidateWindowOps(windows, aggregations); err != nil {
+		return nil, err
+	}
+
+	from := bucketing.From
+	var stages []bson.M
+	if len(windows) > 0 {
+		// Window operations may read buckets before the start of the series
+		from = bucketing.windowFrom(windows)
+		stages = bucketing.windowStages(aggregations, windows)
+	}
+
+	results, err := aggregateRollup(ctx, rollup, from, bucketing.To, bucketing.TruncateExpr("$b"), aggregations, stages...)
+	if err != nil {
+		return nil, err
+	}
+
+	points, err := bucketing.fill(results, aggregations)
+	if err != nil {
+		return nil, err
+	}
+	fillWindows(points, aggregations, windows)
+	return points, nil
+}
+
+// aggregateRollup groups the documents of a rollup in [from, to) by groupKey, like aggregateGroups
+// Groups whose events were all deleted since they were counted are dropped.
+func aggregateRollup(ctx context.Context, rollup models.Rollup, from, to time.Time, groupKey interface{}, aggregations []Aggregation, stages ...bson.M) ([]bson.M, error) {
+	// Counters are the sum of what ingest and the build counted
+	counter := func(name string) bson.M {
+		return bson.M{"$sum": bson.M{"$add": bson.A{
+			bson.M{"$ifNull": bson.A{"$m." + name, 0}},
+			bson.M{"$ifNull": bson.A{"$bm." + name, 0}},
+		}}}
+	}
+
+	groupStage := bson.M{"_id": groupKey, "_count": counter("count")}
+	columns := bson.M{}
+	temporary := bson.A{"_count"}
+	for _, aggregation := range aggregations {
+		if aggregation.Op == constants.AggregationCount {
+			columns[aggregation.Column()] = "$_count"
+			continue
+		}
+
+		sum, values := RollupSumCounter(aggregation.Field), RollupValuesCounter(aggregation.Field)
+		if _, ok := groupStage["_"+sum]; !ok {
+			groupStage["_"+sum] = counter(sum)
+			groupStage["_"+values] = counter(values)
+			temporary = append(temporary, "_"+sum, "_"+values)
+		}
+
+		if aggregation.Op == constants.AggregationSum {
+			columns[aggregation.Column()] = "$_" + sum
+		} else {
+			columns[aggregation.Column()] = bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{"$_" + values, 0}},
+				bson.M{"$divide": bson.A{"$_" + sum, "$_" + values}},
+				nil,
+			}}
+		}
+	}
+
+	filter := bson.M{"r": rollup.Id, "g": rollup.Generation}
+	bounds := bson.M{}
+	if !from.IsZero() {
+		bounds["$gte"] = from
+	}
+	if !to.IsZero() {
+		bounds["$lt"] = to
+	}
+	if len(bounds) > 0 {
+		filter["b"] = bounds
+	}
+
+	pipeline := []bson.M{
+		{"$match": filter},
+		{"$group": groupStage},
+		{"$match": bson.M{"_count": bson.M{"$gt": 0}}},
+		{"$set": columns},
+		{"$unset": temporary},
+		{"$sort": bson.M{"_id": 1}},
+	}
+	pipeline = append(pipeline, stages...)
+
+	collection := database.DBClient.Database().Collection(RollupCollection(rollup.Granularity))
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	results := []bson.M{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	if len(aggregations) == 1 {
+		for _, result := range results {
+			result["value"] = result[aggregations[0].Column()]
+		}
+	}
+
+	return results, nil
+}
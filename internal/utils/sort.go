@@ -7,11 +7,24 @@ import (
 )
 
 // BuildSortOptions converts sortBy and sortOrder into MongoDB-compatible sort options
+// The _id is appended as a tie-breaker so that events with equal sort values keep a stable order
 func BuildSortOptions(sortBy, sortOrder string) bson.D {
 	sortDirection := constants.SortAscending // Default to ascending
 	if sortOrder == constants.SortOrderDesc {
 		sortDirection = constants.SortDescending
 	}
 
-	return bson.D{{Key: sortBy, Value: sortDirection}}
+	field := SortField(sortBy)
+	if field == "_id" {
+		return bson.D{{Key: field, Value: sortDirection}}
+	}
+	return bson.D{{Key: field, Value: sortDirection}, {Key: "_id", Value: sortDirection}}
+}
+
+// SortField maps a sortBy parameter to the stored field name
+func SortField(sortBy string) string {
+	if sortBy == "id" {
+		return "_id"
+	}
+	return sortBy
 }
@@ -0,0 +1,292 @@
+package utils
+
+import (
+	"events-api/internal/constants"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrTooManyBuckets is returned when a time series would have more than constants.MaxTimeSeriesBuckets buckets
+var ErrTooManyBuckets = fmt.Errorf("time series must not have more than %d buckets, narrow the range or widen the interval", constants.MaxTimeSeriesBuckets)
+
+// Interval is the width of the buckets of a time series, BinSize multiples of Unit
+// Unit is a $dateTrunc unit: minute, hour, day, week, month, quarter or year
+type Interval struct {
+	Unit        string
+	BinSize     int
+	StartOfWeek string // sunday or monday, only used by week intervals
+}
+
+// Named intervals accepted besides custom ones
+var namedIntervals = map[string]Interval{
+	constants.IntervalMinute:  {Unit: "minute", BinSize: 1},
+	"5m":                      {Unit: "minute", BinSize: 5},
+	"15m":                     {Unit: "minute", BinSize: 15},
+	constants.IntervalHour:    {Unit: "hour", BinSize: 1},
+	constants.IntervalDay:     {Unit: "day", BinSize: 1},
+	constants.IntervalWeek:    {Unit: "week", BinSize: 1, StartOfWeek: "sunday"},
+	constants.IntervalISOWeek: {Unit: "week", BinSize: 1, StartOfWeek: "monday"},
+	constants.IntervalMonth:   {Unit: "month", BinSize: 1},
+	constants.IntervalQuarter: {Unit: "quarter", BinSize: 1},
+	constants.IntervalYear:    {Unit: "year", BinSize: 1},
+}
+
+// Units of custom intervals such as 30m, 6h or 7d
+var customIntervalPattern = regexp.MustCompile(`^([1-9][0-9]*)([mhd])$`)
+
+var customIntervalUnits = map[string]string{"m": "minute", "h": "hour", "d": "day"}
+
+// ParseInterval parses a named interval (minute, 5m, 15m, hour, day, week, isoweek, month, quarter,
+// year) or a custom number of minutes, hours or days such as 30m, 6h or 7d
+func ParseInterval(interval string) (Interval, error) {
+	if named, ok := namedIntervals[interval]; ok {
+		return named, nil
+	}
+
+	match := customIntervalPattern.FindStringSubmatch(interval)
+	if match == nil {
+		return Interval{}, fmt.Errorf("invalid interval %q", interval)
+	}
+	binSize, err := strconv.Atoi(match[1])
+	if err != nil || binSize > constants.MaxIntervalBinSize {
+		return Interval{}, fmt.Errorf("interval %q must not be more than %d units", interval, constants.MaxIntervalBinSize)
+	}
+
+	return Interval{Unit: customIntervalUnits[match[2]], BinSize: binSize}, nil
+}
+
+// TimeBucketing describes how events are bucketed into a time series
+//
+// Series bounded by both From and To are contiguous: every bucket between them gets a point, and
+// points filled in for empty buckets hold zero for additive aggregations (count, sum, distinct
+// counts) and null for the others (avg, min, ...). Series missing either bound only get points for
+// the buckets with events, as they may span any number of buckets.
+type TimeBucketing struct {
+	Interval  Interval       // width of the buckets
+	TimeField string         // created_at or occurred_at
+	Location  *time.Location // time zone the buckets start and end in
+	From      time.Time      // inclusive start of the series, zero for unbounded
+	To        time.Time      // exclusive end of the series, zero for unbounded
+}
+
+// expr returns the expression computing the start of the bucket of an event
+func (b TimeBucketing) expr() bson.M {
+	return b.TruncateExpr(TimeFieldExpr(b.TimeField))
+}
+
+// TruncateExpr returns the expression computing the start of the bucket containing date
+// $dateTrunc requires MongoDB 5.0
+func (b TimeBucketing) TruncateExpr(date interface{}) bson.M {
+	trunc := bson.M{
+		"date":     date,
+		"unit":     b.Interval.Unit,
+		"timezone": b.location().String(),
+	}
+	if b.Interval.BinSize > 1 {
+		trunc["binSize"] = b.Interval.BinSize
+	}
+	if b.Interval.Unit == "week" {
+		trunc["startOfWeek"] = b.Interval.StartOfWeek
+	}
+	return bson.M{"$dateTrunc": trunc}
+}
+
+// rangeFilter matches the events between From and To, nil when the series is unbounded
+func (b TimeBucketing) rangeFilter() bson.M {
+	return TimeRangeFilter(b.TimeField, b.From, b.To)
+}
+
+// TimeRangeFilter matches the events whose time field is in [from, to)
+// Zero times leave that side of the range open, nil is returned when both are zero
+func TimeRangeFilter(timeField string, from, to time.Time) bson.M {
+	bounds := bson.M{}
+	if !from.IsZero() {
+		bounds["$gte"] = from
+	}
+	if !to.IsZero() {
+		bounds["$lt"] = to
+	}
+	if len(bounds) == 0 {
+		return nil
+	}
+
+	if timeField == constants.TimeFieldOccurredAt {
+		// Same fallback as TimeFieldExpr, written so that both fields can use their indexes
+		return bson.M{"$or": bson.A{
+			bson.M{constants.TimeFieldOccurredAt: bounds},
+			bson.M{constants.TimeFieldOccurredAt: nil, constants.TimeFieldCreatedAt: bounds},
+		}}
+	}
+	return bson.M{constants.TimeFieldCreatedAt: bounds}
+}
+
+// Truncate returns the start of the bucket containing t, like TruncateExpr
+// Bins larger than one unit are counted from 2000-01-01 in the bucketing's location,
+// the reference date $dateTrunc uses
+func (b TimeBucketing) Truncate(t time.Time) time.Time {
+	location := b.location()
+	t = t.In(location)
+	year, month, day := t.Date()
+	binSize := max(b.Interval.BinSize, 1)
+	reference := time.Date(2000, time.January, 1, 0, 0, 0, 0, location)
+
+	switch b.Interval.Unit {
+	case "minute", "hour":
+		width := time.Minute
+		if b.Interval.Unit == "hour" {
+			width = time.Hour
+		}
+		width *= time.Duration(binSize)
+		bins := floorDiv(int64(t.Sub(reference)), int64(width))
+		return reference.Add(time.Duration(bins) * width)
+	case "week":
+		offset := int(t.Weekday())
+		if b.Interval.StartOfWeek == "monday" {
+			offset = (offset + 6) % 7
+		}
+		return time.Date(year, month, day-offset, 0, 0, 0, 0, location)
+	case "month":
+		return time.Date(year, month, 1, 0, 0, 0, 0, location)
+	case "quarter":
+		return time.Date(year, (month-1)/3*3+1, 1, 0, 0, 0, 0, location)
+	case "year":
+		return time.Date(year, time.January, 1, 0, 0, 0, 0, location)
+	default:
+		// Count calendar days, which are not always 24 hours long
+		days := int64(time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Sub(time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)) / (24 * time.Hour))
+		bins := floorDiv(days, int64(binSize))
+		return time.Date(2000, time.January, 1+int(bins)*binSize, 0, 0, 0, 0, location)
+	}
+}
+
+// Next returns the start of the bucket following the one starting at start
+func (b TimeBucketing) Next(start time.Time) time.Time {
+	binSize := max(b.Interval.BinSize, 1)
+	year, month, day := start.Date()
+
+	switch b.Interval.Unit {
+	case "minute":
+		return start.Add(time.Duration(binSize) * time.Minute)
+	case "hour":
+		return start.Add(time.Duration(binSize) * time.Hour)
+	case "week":
+		return time.Date(year, month, day+7, 0, 0, 0, 0, start.Location())
+	case "month":
+		return time.Date(year, month+1, 1, 0, 0, 0, 0, start.Location())
+	case "quarter":
+		return time.Date(year, month+3, 1, 0, 0, 0, 0, start.Location())
+	case "year":
+		return time.Date(year+1, time.January, 1, 0, 0, 0, 0, start.Location())
+	default:
+		return time.Date(year, month, day+binSize, 0, 0, 0, 0, start.Location())
+	}
+}
+
+// floorDiv divides rounding towards negative infinity, for times before the reference date
+func floorDiv(a, b int64) int64 {
+	quotient := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		quotient--
+	}
+	return quotient
+}
+
+// Bounded reports whether both From and To are set, which makes the series contiguous
+func (b TimeBucketing) Bounded() bool {
+	return !b.From.IsZero() && !b.To.IsZero()
+}
+
+// checkBucketCount fails with ErrTooManyBuckets when From and To span too many buckets
+func (b TimeBucketing) checkBucketCount() error {
+	if !b.Bounded() {
+		return nil
+	}
+
+	count := 0
+	for start := b.Truncate(b.From); start.Before(b.To); start = b.Next(start) {
+		if count++; count > constants.MaxTimeSeriesBuckets {
+			return ErrTooManyBuckets
+		}
+	}
+	return nil
+}
+
+// fill returns a contiguous series from the points of the buckets with events, sorted by start
+// Unbounded series are only made of the buckets with events, see TimeBucketing.
+// The _id of the points is turned from the start of the bucket into an RFC3339 timestamp
+func (b TimeBucketing) fill(points []bson.M, aggregations []Aggregation) ([]bson.M, error) {
+	if !b.Bounded() {
+		// Window operations may have read buckets before From
+		var first time.Time
+		if !b.From.IsZero() {
+			first = b.Truncate(b.From)
+		}
+
+		filled := make([]bson.M, 0, len(points))
+		for _, point := range points {
+			start, ok := point["_id"].(primitive.DateTime)
+			if !ok || start.Time().Before(first) {
+				continue
+			}
+			point["_id"] = b.Format(start.Time())
+			filled = append(filled, point)
+		}
+		return filled, nil
+	}
+
+	byStart := make(map[int64]bson.M, len(points))
+	for _, point := range points {
+		if start, ok := point["_id"].(primitive.DateTime); ok {
+			byStart[int64(start)] = point
+		}
+	}
+
+	filled := []bson.M{}
+	for start := b.Truncate(b.From); start.Before(b.To); start = b.Next(start) {
+		if len(filled) >= constants.MaxTimeSeriesBuckets {
+			return nil, ErrTooManyBuckets
+		}
+
+		point, ok := byStart[start.UnixMilli()]
+		if !ok {
+			point = emptyPoint(aggregations)
+		}
+		point["_id"] = b.Format(start)
+		filled = append(filled, point)
+	}
+
+	return filled, nil
+}
+
+// emptyPoint returns the point of a bucket without events
+func emptyPoint(aggregations []Aggregation) bson.M {
+	point := bson.M{}
+	for _, aggregation := range aggregations {
+		if aggregation.additive() {
+			point[aggregation.Column()] = 0
+		} else {
+			point[aggregation.Column()] = nil
+		}
+	}
+	if len(aggregations) == 1 {
+		point["value"] = point[aggregations[0].Column()]
+	}
+	return point
+}
+
+// Format returns the start of a bucket as an RFC3339 timestamp in the bucketing's location
+func (b TimeBucketing) Format(start time.Time) string {
+	return start.In(b.location()).Format(time.RFC3339)
+}
+
+func (b TimeBucketing) location() *time.Location {
+	if b.Location == nil {
+		return time.UTC
+	}
+	return b.Location
+}
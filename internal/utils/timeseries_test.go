@@ -0,0 +1,262 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %s is not available: %v", name, err)
+	}
+	return location
+}
+
+func TestParseInterval(t *testing.T) {
+	tests := []struct {
+		interval string
+		want     Interval
+		wantErr  bool
+	}{
+		{interval: "minute", want: Interval{Unit: "minute", BinSize: 1}},
+		{interval: "15m", want: Interval{Unit: "minute", BinSize: 15}},
+		{interval: "hour", want: Interval{Unit: "hour", BinSize: 1}},
+		{interval: "day", want: Interval{Unit: "day", BinSize: 1}},
+		{interval: "week", want: Interval{Unit: "week", BinSize: 1, StartOfWeek: "sunday"}},
+		{interval: "isoweek", want: Interval{Unit: "week", BinSize: 1, StartOfWeek: "monday"}},
+		{interval: "month", want: Interval{Unit: "month", BinSize: 1}},
+		{interval: "quarter", want: Interval{Unit: "quarter", BinSize: 1}},
+		{interval: "year", want: Interval{Unit: "year", BinSize: 1}},
+		{interval: "30m", want: Interval{Unit: "minute", BinSize: 30}},
+		{interval: "6h", want: Interval{Unit: "hour", BinSize: 6}},
+		{interval: "7d", want: Interval{Unit: "day", BinSize: 7}},
+		{interval: "1000d", want: Interval{Unit: "day", BinSize: 1000}},
+		{interval: "1001d", wantErr: true},
+		{interval: "0h", wantErr: true},
+		{interval: "07d", wantErr: true},
+		{interval: "2w", wantErr: true},
+		{interval: "-1d", wantErr: true},
+		{interval: "Day", wantErr: true},
+		{interval: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.interval, func(t *testing.T) {
+			got, err := ParseInterval(tt.interval)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseInterval(%q) error = %v, wantErr %v", tt.interval, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseInterval(%q) = %+v, want %+v", tt.interval, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncateAndNext(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	berlin := mustLoadLocation(t, "Europe/Berlin")
+
+	tests := []struct {
+		name     string
+		interval string
+		location *time.Location
+		t        time.Time
+		start    time.Time // start of the bucket containing t
+		next     time.Time // start of the following bucket
+	}{
+		{
+			name: "minute", interval: "minute", location: time.UTC,
+			t:     time.Date(2024, 3, 10, 10, 37, 59, 999, time.UTC),
+			start: time.Date(2024, 3, 10, 10, 37, 0, 0, time.UTC),
+			next:  time.Date(2024, 3, 10, 10, 38, 0, 0, time.UTC),
+		},
+		{
+			name: "15 minutes", interval: "15m", location: time.UTC,
+			t:     time.Date(2024, 3, 10, 10, 37, 0, 0, time.UTC),
+			start: time.Date(2024, 3, 10, 10, 30, 0, 0, time.UTC),
+			next:  time.Date(2024, 3, 10, 10, 45, 0, 0, time.UTC),
+		},
+		{
+			name: "6 hours", interval: "6h", location: time.UTC,
+			t:     time.Date(2024, 3, 10, 13, 0, 0, 0, time.UTC),
+			start: time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC),
+			next:  time.Date(2024, 3, 10, 18, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "hour before 2000", interval: "hour", location: time.UTC,
+			t:     time.Date(1999, 12, 31, 23, 59, 0, 0, time.UTC),
+			start: time.Date(1999, 12, 31, 23, 0, 0, 0, time.UTC),
+			next:  time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "day", interval: "day", location: time.UTC,
+			t:     time.Date(2024, 3, 10, 15, 4, 5, 0, time.UTC),
+			start: time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC),
+			next:  time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "day in another zone", interval: "day", location: newYork,
+			t:     time.Date(2024, 3, 10, 3, 0, 0, 0, time.UTC), // 23:00 on the 9th in New York
+			start: time.Date(2024, 3, 9, 0, 0, 0, 0, newYork),
+			next:  time.Date(2024, 3, 10, 0, 0, 0, 0, newYork),
+		},
+		{
+			name: "23 hour day", interval: "day", location: newYork,
+			t:     time.Date(2024, 3, 10, 15, 0, 0, 0, newYork),
+			start: time.Date(2024, 3, 10, 0, 0, 0, 0, newYork),
+			next:  time.Date(2024, 3, 11, 0, 0, 0, 0, newYork),
+		},
+		{
+			name: "25 hour day", interval: "day", location: berlin,
+			t:     time.Date(2024, 10, 27, 23, 30, 0, 0, berlin),
+			start: time.Date(2024, 10, 27, 0, 0, 0, 0, berlin),
+			next:  time.Date(2024, 10, 28, 0, 0, 0, 0, berlin),
+		},
+		{
+			name: "7 days", interval: "7d", location: time.UTC,
+			t:     time.Date(2000, 1, 9, 12, 0, 0, 0, time.UTC),
+			start: time.Date(2000, 1, 8, 0, 0, 0, 0, time.UTC),
+			next:  time.Date(2000, 1, 15, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "7 days before 2000", interval: "7d", location: time.UTC,
+			t:     time.Date(1999, 12, 31, 12, 0, 0, 0, time.UTC),
+			start: time.Date(1999, 12, 25, 0, 0, 0, 0, time.UTC),
+			next:  time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "7 days across a DST change", interval: "7d", location: newYork,
+			t:     time.Date(2024, 3, 12, 8, 0, 0, 0, newYork),
+			start: time.Date(2024, 3, 9, 0, 0, 0, 0, newYork),
+			next:  time.Date(2024, 3, 16, 0, 0, 0, 0, newYork),
+		},
+		{
+			name: "week", interval: "week", location: time.UTC,
+			t:     time.Date(2024, 3, 13, 9, 0, 0, 0, time.UTC), // a Wednesday
+			start: time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC),
+			next:  time.Date(2024, 3, 17, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "ISO week", interval: "isoweek", location: time.UTC,
+			t:     time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC), // a Sunday
+			start: time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC),
+			next:  time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "week across a DST change", interval: "isoweek", location: berlin,
+			t:     time.Date(2024, 3, 31, 12, 0, 0, 0, berlin),
+			start: time.Date(2024, 3, 25, 0, 0, 0, 0, berlin),
+			next:  time.Date(2024, 4, 1, 0, 0, 0, 0, berlin),
+		},
+		{
+			name: "month", interval: "month", location: time.UTC,
+			t:     time.Date(2024, 1, 31, 23, 0, 0, 0, time.UTC),
+			start: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			next:  time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "quarter", interval: "quarter", location: time.UTC,
+			t:     time.Date(2024, 5, 20, 0, 0, 0, 0, time.UTC),
+			start: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+			next:  time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "year", interval: "year", location: berlin,
+			t:     time.Date(2024, 12, 31, 23, 30, 0, 0, time.UTC), // already 2025 in Berlin
+			start: time.Date(2025, 1, 1, 0, 0, 0, 0, berlin),
+			next:  time.Date(2026, 1, 1, 0, 0, 0, 0, berlin),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			interval, err := ParseInterval(tt.interval)
+			if err != nil {
+				t.Fatalf("ParseInterval(%q) error = %v", tt.interval, err)
+			}
+			bucketing := TimeBucketing{Interval: interval, Location: tt.location}
+
+			start := bucketing.Truncate(tt.t)
+			if !start.Equal(tt.start) {
+				t.Errorf("Truncate(%v) = %v, want %v", tt.t, start, tt.start)
+			}
+			if next := bucketing.Next(start); !next.Equal(tt.next) {
+				t.Errorf("Next(%v) = %v, want %v", start, next, tt.next)
+			}
+			if again := bucketing.Truncate(start); !again.Equal(start) {
+				t.Errorf("Truncate(%v) = %v, want the bucket start itself", start, again)
+			}
+		})
+	}
+}
+
+func TestFill(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC) }
+	point := func(d int, count int) bson.M {
+		return bson.M{"_id": primitive.NewDateTimeFromTime(day(d)), "count": count, "value": count}
+	}
+	aggregations := []Aggregation{{Op: "count"}}
+	interval := Interval{Unit: "day", BinSize: 1}
+
+	tests := []struct {
+		name   string
+		from   time.Time
+		to     time.Time
+		points []bson.M
+		want   []string // bucket starts returned
+	}{
+		{"unbounded keeps the buckets with events", time.Time{}, time.Time{}, []bson.M{point(1, 1), point(5, 2)}, []string{"2024-03-01T00:00:00Z", "2024-03-05T00:00:00Z"}},
+		{"unbounded without events", time.Time{}, time.Time{}, nil, []string{}},
+		{"from only drops earlier buckets", day(2), time.Time{}, []bson.M{point(1, 1), point(5, 2)}, []string{"2024-03-05T00:00:00Z"}},
+		{"bounded fills every bucket", day(1), day(4), []bson.M{point(2, 3)}, []string{"2024-03-01T00:00:00Z", "2024-03-02T00:00:00Z", "2024-03-03T00:00:00Z"}},
+		{"bounded without events", day(1), day(3), nil, []string{"2024-03-01T00:00:00Z", "2024-03-02T00:00:00Z"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucketing := TimeBucketing{Interval: interval, From: tt.from, To: tt.to}
+			filled, err := bucketing.fill(tt.points, aggregations)
+			if err != nil {
+				t.Fatalf("fill() error = %v", err)
+			}
+
+			got := make([]string, len(filled))
+			for i, point := range filled {
+				got[i], _ = point["_id"].(string)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("fill() buckets = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("fill() buckets = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestFillLongUnboundedSeries(t *testing.T) {
+	// A minute series over a week has more buckets than a bounded series may have
+	bucketing := TimeBucketing{Interval: Interval{Unit: "minute", BinSize: 1}}
+	first := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	points := []bson.M{
+		{"_id": primitive.NewDateTimeFromTime(first), "count": 1},
+		{"_id": primitive.NewDateTimeFromTime(first.Add(7 * 24 * time.Hour)), "count": 1},
+	}
+
+	filled, err := bucketing.fill(points, []Aggregation{{Op: "count"}})
+	if err != nil {
+		t.Fatalf("fill() error = %v", err)
+	}
+	if len(filled) != 2 {
+		t.Errorf("fill() returned %d buckets, want 2", len(filled))
+	}
+}
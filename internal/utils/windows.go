@@ -0,0 +1,282 @@
+package utils
+
+import (
+	"events-api/internal/constants"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// WindowOp is a window operation computed over the buckets of a time series
+// It applies to every aggregation column and is returned in a column of its own, see Column.
+//
+// - moving_avg averages the last Size buckets, the current one included. Buckets without events
+// count as zero for additive aggregations and are skipped by the others.
+// - cumulative is the running total of an additive aggregation from the start of the series.
+// - rate is the change from the previous bucket in percent, null when either bucket has no value
+// or the previous one is zero.
+//
+// Buckets before From are read as needed, so the first buckets of a series get their moving
+// averages and rates like the others.
+type WindowOp struct {
+	Op   string
+	Size int // number of buckets averaged by moving_avg
+}
+
+// Column returns the name of the result column holding the operation over an aggregation,
+// e.g. count_moving_avg_7, sum_amount_cumulative or count_rate
+func (w WindowOp) Column(aggregation Aggregation) string {
+	if w.Op == constants.WindowMovingAvg {
+		return fmt.Sprintf("%s_%s_%d", aggregation.Column(), w.Op, w.Size)
+	}
+	return aggregation.Column() + "_" + w.Op
+}
+
+// warmup returns the number of buckets before the current one the operation reads
+func (w WindowOp) warmup() int {
+	switch w.Op {
+	case constants.WindowMovingAvg:
+		return w.Size - 1
+	case constants.WindowRate:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// emptyValue returns the value of the operation in a bucket without events or earlier ones
+func (w WindowOp) emptyValue(aggregation Aggregation) interface{} {
+	if w.Op != constants.WindowRate && aggregation.additive() {
+		return 0
+	}
+	return nil
+}
+
+// ValidateWindowOps checks that window operations can be computed over the aggregations
+// Percentiles and distinct counts are finalized after the aggregation pipeline, so they can't be
+// windowed. Running totals only make sense for additive aggregations.
+func ValidateWindowOps(windows []WindowOp, aggregations []Aggregation) error {
+	if len(windows) == 0 {
+		return nil
+	}
+
+	for _, aggregation := range aggregations {
+		if _, ok := percentileOps[aggregation.Op]; ok ||
+			aggregation.Op == constants.AggregationCountDistinct ||
+			aggregation.Op == constants.AggregationApproxCountDistinct {
+			return fmt.Errorf("window operations are not supported for %s aggregations", aggregation.Op)
+		}
+		for _, window := range windows {
+			if window.Op == constants.WindowCumulative && !aggregation.additive() {
+				return fmt.Errorf("cumulative is only supported for count and sum aggregations, not %s", aggregation.Op)
+			}
+		}
+	}
+	return nil
+}
+
+// windowFrom returns the start of the first bucket window operations read, From when none
+// reads earlier buckets or the series has no start
+func (b TimeBucketing) windowFrom(windows []WindowOp) time.Time {
+	if b.From.IsZero() {
+		return b.From
+	}
+
+	warmup := 0
+	for _, window := range windows {
+		warmup = max(warmup, window.warmup())
+	}
+	if warmup == 0 {
+		return b.From
+	}
+	return b.start(b.index(b.Truncate(b.From)) - int64(warmup))
+}
+
+// windowStages returns the stages computing window operations, run after the buckets are grouped
+//
+// Buckets are numbered from a reference bucket, and $densify (MongoDB 5.1) adds the numbers of the
+// buckets without events, so that the documents windows of $setWindowFields cover contiguous
+// buckets. The added buckets get their start back from their number. With both From and To set
+// every bucket of the series is added, otherwise only those between the first and the last bucket
+// with events, which unbounded series are then made of.
+func (b TimeBucketing) windowStages(aggregations []Aggregation, windows []WindowOp) []bson.M {
+	bounds := interface{}("full")
+	if !b.From.IsZero() && !b.To.IsZero() {
+		bounds = bson.A{b.index(b.Truncate(b.windowFrom(windows))), b.index(b.Truncate(b.To.Add(-time.Nanosecond))) + 1}
+	}
+
+	// Buckets read before the start of the series don't count towards running totals
+	inSeries := interface{}(true)
+	if !b.From.IsZero() {
+		inSeries = bson.M{"$gte": bson.A{"$_i", b.index(b.Truncate(b.From))}}
+	}
+
+	densified := bson.M{"_id": bson.M{"$ifNull": bson.A{"$_id", b.startExpr("$_i")}}}
+	outputs := bson.M{}
+	finals := bson.M{}
+	var temporary bson.A
+	for _, aggregation := range aggregations {
+		value := "$" + aggregation.Column()
+		if aggregation.additive() {
+			densified[aggregation.Column()] = bson.M{"$ifNull": bson.A{value, 0}}
+		}
+
+		for _, window := range windows {
+			column := window.Column(aggregation)
+			switch window.Op {
+			case constants.WindowMovingAvg:
+				documents := bson.M{"documents": bson.A{-(window.Size - 1), 0}}
+				if !aggregation.additive() {
+					outputs[column] = bson.M{"$avg": value, "window": documents}
+					continue
+				}
+				// Buckets without events count as zero, so the sum is divided by the window size
+				outputs["_"+column] = bson.M{"$sum": value, "window": documents}
+				finals[column] = bson.M{"$divide": bson.A{"$_" + column, window.Size}}
+			case constants.WindowCumulative:
+				outputs[column] = bson.M{
+					"$sum":   bson.M{"$cond": bson.A{inSeries, value, 0}},
+					"window": bson.M{"documents": bson.A{"unbounded", "current"}},
+				}
+			case constants.WindowRate:
+				outputs["_"+column] = bson.M{"$shift": bson.M{"output": value, "by": -1}}
+				finals[column] = bson.M{"$let": bson.M{
+					"vars": bson.M{"previous": bson.M{"$ifNull": bson.A{"$_" + column, 0}}, "current": bson.M{"$ifNull": bson.A{value, nil}}},
+					"in": bson.M{"$cond": bson.A{
+						bson.M{"$or": bson.A{bson.M{"$eq": bson.A{"$$previous", 0}}, bson.M{"$eq": bson.A{"$$current", nil}}}},
+						nil,
+						bson.M{"$multiply": bson.A{bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{"$$current", "$$previous"}}, "$$previous"}}, 100}},
+					}},
+				}}
+			}
+			if _, ok := outputs["_"+column]; ok {
+				temporary = append(temporary, "_"+column)
+			}
+		}
+	}
+
+	stages := []bson.M{
+		{"$set": bson.M{"_i": b.indexExpr("$_id")}},
+		{"$densify": bson.M{"field": "_i", "range": bson.M{"step": 1, "bounds": bounds}}},
+		{"$set": densified},
+		{"$setWindowFields": bson.M{"sortBy": bson.M{"_i": 1}, "output": outputs}},
+	}
+	if len(finals) > 0 {
+		stages = append(stages, bson.M{"$set": finals})
+	}
+	return append(stages,
+		bson.M{"$unset": append(temporary, "_i")},
+		bson.M{"$sort": bson.M{"_id": 1}},
+	)
+}
+
+// fillWindows sets the window columns of the points filled in for buckets without events
+func fillWindows(points []bson.M, aggregations []Aggregation, windows []WindowOp) {
+	for _, point := range points {
+		for _, aggregation := range aggregations {
+			for _, window := range windows {
+				column := window.Column(aggregation)
+				if _, ok := point[column]; !ok {
+					point[column] = window.emptyValue(aggregation)
+				}
+			}
+		}
+	}
+}
+
+// reference returns the start of the bucket buckets are numbered from, the one of 2000-01-01
+func (b TimeBucketing) reference() time.Time {
+	return b.Truncate(time.Date(2000, time.January, 1, 0, 0, 0, 0, b.location()))
+}
+
+// unitDuration returns the width of a minute or hour bucket
+func (b TimeBucketing) unitDuration() time.Duration {
+	width := time.Minute
+	if b.Interval.Unit == "hour" {
+		width = time.Hour
+	}
+	return width * time.Duration(max(b.Interval.BinSize, 1))
+}
+
+// index returns the number of the bucket starting at start, counted from the reference bucket
+func (b TimeBucketing) index(start time.Time) int64 {
+	reference := b.reference()
+	start = start.In(b.location())
+	binSize := int64(max(b.Interval.BinSize, 1))
+	months := int64((start.Year()-reference.Year())*12 + int(start.Month()-reference.Month()))
+
+	switch b.Interval.Unit {
+	case "minute", "hour":
+		return floorDiv(int64(start.Sub(reference)), int64(b.unitDuration()))
+	case "week":
+		return floorDiv(int64(calendarDays(reference, start)), 7)
+	case "month":
+		return months
+	case "quarter":
+		return floorDiv(months, 3)
+	case "year":
+		return int64(start.Year() - reference.Year())
+	default:
+		return floorDiv(int64(calendarDays(reference, start)), binSize)
+	}
+}
+
+// start returns the start of the bucket with the given number, the inverse of index
+func (b TimeBucketing) start(index int64) time.Time {
+	reference := b.reference()
+	n := int(index)
+
+	switch b.Interval.Unit {
+	case "minute", "hour":
+		return reference.Add(time.Duration(index) * b.unitDuration())
+	case "week":
+		return reference.AddDate(0, 0, 7*n)
+	case "month":
+		return reference.AddDate(0, n, 0)
+	case "quarter":
+		return reference.AddDate(0, 3*n, 0)
+	case "year":
+		return reference.AddDate(n, 0, 0)
+	default:
+		return reference.AddDate(0, 0, n*max(b.Interval.BinSize, 1))
+	}
+}
+
+// indexExpr returns the expression computing the number of the bucket starting at date, like index
+func (b TimeBucketing) indexExpr(date interface{}) bson.M {
+	if b.Interval.Unit == "minute" || b.Interval.Unit == "hour" {
+		return bson.M{"$toLong": bson.M{"$floor": bson.M{"$divide": bson.A{
+			bson.M{"$subtract": bson.A{date, b.reference()}},
+			b.unitDuration().Milliseconds(),
+		}}}}
+	}
+
+	diff := bson.M{
+		"startDate": b.reference(),
+		"endDate":   date,
+		"unit":      b.Interval.Unit,
+		"timezone":  b.location().String(),
+	}
+	if b.Interval.Unit == "week" {
+		diff["startOfWeek"] = b.Interval.StartOfWeek
+	}
+	return bson.M{"$toLong": bson.M{"$floor": bson.M{"$divide": bson.A{
+		bson.M{"$dateDiff": diff},
+		max(b.Interval.BinSize, 1),
+	}}}}
+}
+
+// startExpr returns the expression computing the start of the bucket with the given number, like start
+func (b TimeBucketing) startExpr(index interface{}) bson.M {
+	if b.Interval.Unit == "minute" || b.Interval.Unit == "hour" {
+		return bson.M{"$add": bson.A{b.reference(), bson.M{"$multiply": bson.A{index, b.unitDuration().Milliseconds()}}}}
+	}
+
+	return bson.M{"$dateAdd": bson.M{
+		"startDate": b.reference(),
+		"unit":      b.Interval.Unit,
+		"amount":    bson.M{"$multiply": bson.A{index, max(b.Interval.BinSize, 1)}},
+		"timezone":  b.location().String(),
+	}}
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"events-api/internal/constants"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBucketIndexRoundTrip(t *testing.T) {
+	locations := []*time.Location{
+		time.UTC,
+		mustLoadLocation(t, "America/New_York"),
+		mustLoadLocation(t, "Europe/Berlin"),
+		mustLoadLocation(t, "Australia/Lord_Howe"), // 30 minute DST shift
+		mustLoadLocation(t, "Asia/Kolkata"),        // half hour offset, no DST
+	}
+	intervals := []string{"minute", "15m", "hour", "6h", "day", "7d", "week", "isoweek", "month", "quarter", "year"}
+
+	// Around DST changes in the zones above, the reference date and before it
+	times := []time.Time{
+		time.Date(2024, 3, 10, 6, 59, 0, 0, time.UTC),
+		time.Date(2024, 3, 10, 7, 30, 0, 0, time.UTC),
+		time.Date(2024, 3, 31, 0, 30, 0, 0, time.UTC),
+		time.Date(2024, 3, 31, 1, 30, 0, 0, time.UTC),
+		time.Date(2024, 4, 6, 15, 15, 0, 0, time.UTC),
+		time.Date(2024, 10, 27, 0, 59, 0, 0, time.UTC),
+		time.Date(2024, 10, 27, 1, 1, 0, 0, time.UTC),
+		time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC),
+		time.Date(2024, 11, 3, 6, 30, 0, 0, time.UTC),
+		time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1999, 12, 31, 23, 59, 59, 0, time.UTC),
+		time.Date(1999, 10, 31, 6, 30, 0, 0, time.UTC),
+		time.Date(1987, 6, 15, 12, 0, 0, 0, time.UTC),
+		time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	for _, location := range locations {
+		for _, name := range intervals {
+			interval, err := ParseInterval(name)
+			if err != nil {
+				t.Fatalf("ParseInterval(%q) error = %v", name, err)
+			}
+			bucketing := TimeBucketing{Interval: interval, Location: location}
+
+			t.Run(fmt.Sprintf("%s/%s", location, name), func(t *testing.T) {
+				for _, instant := range times {
+					start := bucketing.Truncate(instant)
+					index := bucketing.index(start)
+					if got := bucketing.start(index); !got.Equal(start) {
+						t.Errorf("start(index(%v)) = %v, want Truncate = %v", instant, got, start)
+					}
+					if got := bucketing.index(bucketing.Next(start)); got != index+1 {
+						t.Errorf("index(Next(%v)) = %d, want %d", start, got, index+1)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestBucketIndex(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+
+	tests := []struct {
+		name     string
+		interval string
+		location *time.Location
+		start    time.Time
+		want     int64
+	}{
+		{"reference day", "day", time.UTC, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), 0},
+		{"day before the reference", "day", time.UTC, time.Date(1999, 12, 31, 0, 0, 0, 0, time.UTC), -1},
+		{"days over a DST change", "day", newYork, time.Date(2000, 4, 3, 0, 0, 0, 0, newYork), 93},
+		{"hours", "hour", time.UTC, time.Date(2000, 1, 2, 1, 0, 0, 0, time.UTC), 25},
+		{"hours before the reference", "hour", time.UTC, time.Date(1999, 12, 31, 22, 0, 0, 0, time.UTC), -2},
+		{"7 day bins before the reference", "7d", time.UTC, time.Date(1999, 12, 25, 0, 0, 0, 0, time.UTC), -1},
+		{"months", "month", time.UTC, time.Date(2001, 2, 1, 0, 0, 0, 0, time.UTC), 13},
+		{"months before the reference", "month", time.UTC, time.Date(1999, 11, 1, 0, 0, 0, 0, time.UTC), -2},
+		{"quarters before the reference", "quarter", time.UTC, time.Date(1999, 10, 1, 0, 0, 0, 0, time.UTC), -1},
+		{"years", "year", time.UTC, time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC), -10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			interval, err := ParseInterval(tt.interval)
+			if err != nil {
+				t.Fatalf("ParseInterval(%q) error = %v", tt.interval, err)
+			}
+			bucketing := TimeBucketing{Interval: interval, Location: tt.location}
+			if got := bucketing.index(tt.start); got != tt.want {
+				t.Errorf("index(%v) = %d, want %d", tt.start, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWindowFrom(t *testing.T) {
+	from := time.Date(2024, 3, 12, 0, 0, 0, 0, time.UTC)
+	bucketing := TimeBucketing{Interval: Interval{Unit: "day", BinSize: 1}, From: from}
+
+	tests := []struct {
+		name    string
+		windows []WindowOp
+		want    time.Time
+	}{
+		{"cumulative reads no earlier bucket", []WindowOp{{Op: constants.WindowCumulative}}, from},
+		{"rate reads the previous bucket", []WindowOp{{Op: constants.WindowRate}}, from.AddDate(0, 0, -1)},
+		{"moving average reads size - 1 buckets", []WindowOp{{Op: constants.WindowMovingAvg, Size: 7}}, from.AddDate(0, 0, -6)},
+		{"the widest window wins", []WindowOp{{Op: constants.WindowRate}, {Op: constants.WindowMovingAvg, Size: 3}}, from.AddDate(0, 0, -2)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bucketing.windowFrom(tt.windows); !got.Equal(tt.want) {
+				t.Errorf("windowFrom() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"events-api/internal/constants"
 	"events-api/internal/database"
 	"events-api/internal/queue"
+	"events-api/internal/rollups"
 	"events-api/internal/routes"
 	"log"
 	"net/http"
@@ -13,6 +14,7 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata" // Embedded time zone database, the runtime image ships without one
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/compress"
@@ -40,7 +42,12 @@ func init() {
 }
 
 func setupApp() *fiber.App {
-	app := fiber.New(fiber.Config{})
+	app := fiber.New(fiber.Config{
+		// Allows NDJSON ingestion to read large bodies line by line instead of buffering them,
+		// the body of every other route is capped by routes.Setup
+		BodyLimit:         constants.MaxRequestBodySize,
+		StreamRequestBody: true,
+	})
 
 	// Middleware
 	app.Use(helmet.New())
@@ -87,6 +94,21 @@ func main() {
 	// Set the global DB client for handlers
 	database.DBClient = client
 
+	indexCtx, cancelIndexes := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := database.EnsureIndexes(indexCtx); err != nil {
+		log.Fatalf("failed to ensure MongoDB indexes: %v", err)
+	}
+	cancelIndexes()
+
+	// Finish building the rollups a previous run left unfinished
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), constants.QueryTimeout)
+		defer cancel()
+		if err := rollups.ResumeBuilds(ctx); err != nil {
+			log.Printf("failed to resume rollup builds: %v", err)
+		}
+	}()
+
 	// Get service configuration
 	eventProcessingMode := pkgConfig.GetEnv("EVENT_PROCESSING_MODE")
 	enableRestAPI := eventProcessingMode == "rest-only" || eventProcessingMode == "hybrid"